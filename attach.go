@@ -0,0 +1,143 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"github.com/Sirupsen/logrus"
+	"github.com/kkBill/mydocker/container"
+	"io"
+	"net"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+)
+
+// 默认的 detach 按键序列：Ctrl-P Ctrl-Q
+const defaultDetachKeys = "ctrl-p,ctrl-q"
+
+// 连接到后台运行的容器，其基本过程如下：
+// 1.连接 monitor 进程监听的 attach.sock
+// 2.容器分配了终端时，把当前终端设置为 raw 模式，并同步窗口大小
+// 3.把容器的输出写到标准输出，把标准输入发给容器，直到容器退出或者按下 detach 按键序列
+func attachContainer(containerName, detachKeys string) {
+	containerInfo, err := getContainerInfoByName(containerName)
+	if err != nil {
+		logrus.Errorf("Get container %s info error %v", containerName, err)
+		return
+	}
+	if containerInfo.Status != container.RUNNING || !containerInfo.Detach {
+		logrus.Errorf("Container %s is not a running detached container", containerName)
+		return
+	}
+	keys, err := parseDetachKeys(detachKeys)
+	if err != nil {
+		logrus.Errorf("Invalid detach keys %s: %v", detachKeys, err)
+		return
+	}
+
+	socketPath := fmt.Sprintf(container.DefaultInfoLocation, containerName) + container.AttachSocket
+	conn, err := net.Dial("unix", socketPath)
+	if err != nil {
+		logrus.Errorf("Attach container %s error %v", containerName, err)
+		return
+	}
+	defer conn.Close()
+
+	if containerInfo.Tty && container.IsTerminal(os.Stdin.Fd()) {
+		oldState, err := container.SetRawTerminal(os.Stdin.Fd())
+		if err != nil {
+			logrus.Errorf("Set raw terminal error %v", err)
+		} else {
+			defer container.RestoreTerminal(os.Stdin.Fd(), oldState)
+		}
+		sendResize(conn)
+		winch := make(chan os.Signal, 1)
+		signal.Notify(winch, syscall.SIGWINCH)
+		defer signal.Stop(winch)
+		go func() {
+			for range winch {
+				sendResize(conn)
+			}
+		}()
+	}
+
+	go func() {
+		if copyInput(conn, os.Stdin, keys) {
+			// 按下了 detach 按键序列，断开连接后下面的输出转发也会结束
+			conn.Close()
+		}
+	}()
+	io.Copy(os.Stdout, conn)
+}
+
+// 把标准输入发给容器，遇到 detach 按键序列时返回 true
+// 按键序列中已经匹配的部分先缓存起来，后续按键不匹配时再一起发给容器
+func copyInput(conn net.Conn, stdin io.Reader, keys []byte) bool {
+	buf := make([]byte, 1024)
+	matched := 0
+	for {
+		n, err := stdin.Read(buf)
+		if n > 0 {
+			var out []byte
+			for _, b := range buf[:n] {
+				if b == keys[matched] {
+					matched++
+					if matched == len(keys) {
+						if len(out) > 0 {
+							writeFrame(conn, frameStdin, out)
+						}
+						return true
+					}
+					continue
+				}
+				out = append(out, keys[:matched]...)
+				matched = 0
+				if b == keys[0] {
+					matched = 1
+					continue
+				}
+				out = append(out, b)
+			}
+			if len(out) > 0 {
+				if err := writeFrame(conn, frameStdin, out); err != nil {
+					return false
+				}
+			}
+		}
+		if err != nil {
+			return false
+		}
+	}
+}
+
+// 把当前终端的窗口大小发给 monitor 进程
+func sendResize(conn net.Conn) {
+	ws, err := container.GetWinsize(os.Stdin.Fd())
+	if err != nil {
+		logrus.Errorf("Get terminal size error %v", err)
+		return
+	}
+	payload := make([]byte, 4)
+	binary.BigEndian.PutUint16(payload[0:2], ws.Rows)
+	binary.BigEndian.PutUint16(payload[2:4], ws.Cols)
+	writeFrame(conn, frameResize, payload)
+}
+
+// 解析 detach 按键序列，格式为逗号分隔的按键，例如 ctrl-p,ctrl-q 或 ctrl-a,d
+func parseDetachKeys(detachKeys string) ([]byte, error) {
+	var keys []byte
+	for _, key := range strings.Split(detachKeys, ",") {
+		key = strings.ToLower(strings.TrimSpace(key))
+		switch {
+		case len(key) == 1:
+			keys = append(keys, key[0])
+		case strings.HasPrefix(key, "ctrl-") && len(key) == 6 && key[5] >= 'a' && key[5] <= 'z':
+			// Ctrl-A 到 Ctrl-Z 对应的编码为 1 到 26
+			keys = append(keys, key[5]-'a'+1)
+		default:
+			return nil, fmt.Errorf("unknown key %q", key)
+		}
+	}
+	return keys, nil
+}
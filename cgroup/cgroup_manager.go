@@ -1,22 +1,62 @@
 package cgroup
 
 import (
+	"errors"
+	"fmt"
+	"os"
+	"path"
+
+	"github.com/Sirupsen/logrus"
 	"github.com/kkBill/mydocker/cgroup/subsystem"
 )
 
+// devices cgroup 的白名单没有生效时 Set 返回这个错误，调用者需要用其他方式限制容器创建设备文件
+var ErrDevicesNotEnforced = errors.New("devices cgroup allowlist is not enforced")
+
 // 通过 CgroupManager 把不同的资源限制模块(subsystem)给管理起来
 type CgroupManager struct {
 	Path     string
 	Resource *subsystem.ResourceConfig
+	rootless bool   //普通用户运行，只能使用委派给自己的 cgroup v2
+	v2Path   string //rootless 模式下容器在 cgroup v2 中的完整路径，为空表示无法限制资源
 }
 
+// rootless 模式下只有 cgroup v2 能把子树委派给普通用户，
+// 找不到可用的委派 cgroup 时不做资源限制，只给出警告
 func NewCgroupManager(path string) *CgroupManager {
-	return &CgroupManager{
+	c := &CgroupManager{
 		Path: path, // 记得加 ","
 	}
+	if os.Geteuid() != 0 {
+		c.rootless = true
+		c.v2Path = rootlessCgroupPath(path)
+	}
+	return c
+}
+
+func rootlessCgroupPath(cgroupPath string) string {
+	if !IsCgroupV2() {
+		logrus.Warnf("cgroup v2 is not available, resource limits are ignored in rootless mode")
+		return ""
+	}
+	parent, err := setupRootlessCgroup()
+	if err != nil {
+		logrus.Warnf("no delegated cgroup v2 for the current user, resource limits are ignored: %v", err)
+		return ""
+	}
+	return path.Join(parent, cgroupPath)
 }
 
 func (c *CgroupManager) Apply(pid int) error {
+	if c.rootless {
+		if c.v2Path == "" {
+			return nil
+		}
+		if err := applyV2(c.v2Path, pid); err != nil {
+			logrus.Warnf("apply cgroup %s error, resource limits are ignored: %v", c.v2Path, err)
+		}
+		return nil
+	}
 	for _, subSys := range subsystem.SubsystemsItems {
 		_ = subSys.Apply(c.Path, pid)
 	}
@@ -24,15 +64,44 @@ func (c *CgroupManager) Apply(pid int) error {
 }
 
 func (c *CgroupManager) Set(res *subsystem.ResourceConfig) error {
+	if c.rootless {
+		if c.v2Path == "" {
+			if len(res.Devices) > 0 {
+				return ErrDevicesNotEnforced
+			}
+			return nil
+		}
+		if err := setV2(c.v2Path, res); err != nil {
+			logrus.Warnf("set cgroup %s error, some resource limits are ignored: %v", c.v2Path, err)
+		}
+		// cgroup v2 的设备控制需要加载 eBPF 程序，这里没有实现
+		if len(res.Devices) > 0 {
+			return ErrDevicesNotEnforced
+		}
+		return nil
+	}
+	var err error
 	for _, subSys := range subsystem.SubsystemsItems {
-		_ = subSys.Set(c.Path, res)
+		if setErr := subSys.Set(c.Path, res); setErr != nil {
+			if subSys.Name() == "devices" {
+				err = fmt.Errorf("%v: %v", ErrDevicesNotEnforced, setErr)
+				continue
+			}
+			logrus.Warnf("set cgroup %s %s error: %v", subSys.Name(), c.Path, setErr)
+		}
 	}
-	return nil
+	return err
 }
 
 func (c *CgroupManager) Remove() error  {
+	if c.rootless {
+		if c.v2Path != "" {
+			_ = os.Remove(c.v2Path)
+		}
+		return nil
+	}
 	for _, subSys := range subsystem.SubsystemsItems {
 		_ = subSys.Remove(c.Path)
 	}
 	return nil
-}
\ No newline at end of file
+}
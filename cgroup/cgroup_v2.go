@@ -0,0 +1,163 @@
+package cgroup
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/kkBill/mydocker/cgroup/subsystem"
+)
+
+// cgroup v2 统一层级的挂载点和文件系统类型
+const (
+	cgroupV2Root  = "/sys/fs/cgroup"
+	cgroup2Magic  = 0x63677270
+	rootlessGroup = "mydocker" //委派的 cgroup 下为 mydocker 单独创建一层，容器的 cgroup 都放在这里
+)
+
+// rootless 模式下需要的控制器
+var v2Controllers = []string{"cpu", "cpuset", "memory", "pids"}
+
+// /sys/fs/cgroup 是否挂载为 cgroup v2
+func IsCgroupV2() bool {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(cgroupV2Root, &st); err != nil {
+		return false
+	}
+	return st.Type == cgroup2Magic
+}
+
+// 找到 systemd 等委派给当前用户的 cgroup：
+// 从 /proc/self/cgroup 中 0:: 开头的当前 cgroup 开始，一直向上找到当前用户仍然有写权限的最上层目录
+func delegatedCgroup() (string, error) {
+	f, err := os.Open("/proc/self/cgroup")
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	current := ""
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if strings.HasPrefix(scanner.Text(), "0::") {
+			current = path.Join(cgroupV2Root, strings.TrimPrefix(scanner.Text(), "0::"))
+			break
+		}
+	}
+	if current == "" {
+		return "", fmt.Errorf("no cgroup v2 entry in /proc/self/cgroup")
+	}
+	if syscall.Access(current, 2) != nil {
+		return "", fmt.Errorf("cgroup %s is not delegated to the current user", current)
+	}
+	for current != cgroupV2Root {
+		parent := path.Dir(current)
+		if syscall.Access(parent, 2) != nil || syscall.Access(path.Join(parent, "cgroup.procs"), 2) != nil {
+			break
+		}
+		current = parent
+	}
+	return current, nil
+}
+
+// 在委派的 cgroup 下创建 mydocker 这一层，并为子 cgroup 启用需要的控制器
+// 委派时没有开放的控制器会被跳过，对应的资源限制不生效
+func setupRootlessCgroup() (string, error) {
+	delegated, err := delegatedCgroup()
+	if err != nil {
+		return "", err
+	}
+	parents := []string{delegated, path.Join(delegated, rootlessGroup)}
+	if err := os.MkdirAll(parents[1], 0755); err != nil {
+		return "", err
+	}
+	for _, p := range parents {
+		for _, controller := range v2Controllers {
+			ioutil.WriteFile(path.Join(p, "cgroup.subtree_control"), []byte("+"+controller), 0644)
+		}
+	}
+	return parents[1], nil
+}
+
+// 把 cgroup v1 中 cpu.shares 的取值 [2, 262144] 换算为 cgroup v2 中 cpu.weight 的取值 [1, 10000]
+func cpuSharesToWeight(shares uint64) uint64 {
+	if shares == 0 {
+		return 0
+	}
+	if shares < 2 {
+		shares = 2
+	}
+	return 1 + ((shares-2)*9999)/262142
+}
+
+// memory.max 只接受字节数，把 100m 这样带单位的内存限制换算成字节
+func memoryBytes(limit string) (string, error) {
+	s := strings.TrimSuffix(strings.ToLower(strings.TrimSpace(limit)), "b")
+	unit := int64(1)
+	if s != "" {
+		switch s[len(s)-1] {
+		case 'k':
+			unit = 1 << 10
+		case 'm':
+			unit = 1 << 20
+		case 'g':
+			unit = 1 << 30
+		}
+		if unit != 1 {
+			s = s[:len(s)-1]
+		}
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || n <= 0 {
+		return "", fmt.Errorf("invalid memory limit %q", limit)
+	}
+	return strconv.FormatInt(n*unit, 10), nil
+}
+
+// 在 cgroup v2 中设置资源限制，对应的控制器没有启用时文件不存在，返回错误
+func setV2(cgroupPath string, res *subsystem.ResourceConfig) error {
+	if err := os.MkdirAll(cgroupPath, 0755); err != nil {
+		return err
+	}
+	if res.MemoryLimit != "" {
+		bytes, err := memoryBytes(res.MemoryLimit)
+		if err != nil {
+			return err
+		}
+		if err := ioutil.WriteFile(path.Join(cgroupPath, "memory.max"), []byte(bytes), 0644); err != nil {
+			return fmt.Errorf("set cgroup memory fail %v", err)
+		}
+	}
+	if res.CpuShare != "" {
+		shares, err := strconv.ParseUint(res.CpuShare, 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid cpu share %q", res.CpuShare)
+		}
+		weight := strconv.FormatUint(cpuSharesToWeight(shares), 10)
+		if err := ioutil.WriteFile(path.Join(cgroupPath, "cpu.weight"), []byte(weight), 0644); err != nil {
+			return fmt.Errorf("set cgroup cpu weight fail %v", err)
+		}
+	}
+	if res.CpuSet != "" {
+		if err := ioutil.WriteFile(path.Join(cgroupPath, "cpuset.cpus"), []byte(res.CpuSet), 0644); err != nil {
+			return fmt.Errorf("set cgroup cpuset fail %v", err)
+		}
+	}
+	if res.PidsLimit != "" {
+		if err := ioutil.WriteFile(path.Join(cgroupPath, "pids.max"), []byte(res.PidsLimit), 0644); err != nil {
+			return fmt.Errorf("set cgroup pids limit fail %v", err)
+		}
+	}
+	return nil
+}
+
+func applyV2(cgroupPath string, pid int) error {
+	if err := ioutil.WriteFile(path.Join(cgroupPath, "cgroup.procs"), []byte(strconv.Itoa(pid)), 0644); err != nil {
+		return fmt.Errorf("set cgroup proc fail %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,121 @@
+package cgroup
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kkBill/mydocker/cgroup/subsystem"
+)
+
+// cpuacct.stat 中 cpu 时间的单位，即 USER_HZ
+const userHZ = 100
+
+// 从 cgroup 中读取的资源使用情况，在容器退出之后、删除 cgroup 之前读取
+type Stats struct {
+	CpuUser        time.Duration //用户态 cpu 时间
+	CpuSystem      time.Duration //内核态 cpu 时间
+	MemoryMaxUsage uint64        //内存使用的峰值，单位为字节
+	OomKills       uint64        //因为超过内存限制被 OOM killer 杀死的进程数
+}
+
+// 读取容器 cgroup 的资源使用情况，内核没有提供的统计项为 0
+func (c *CgroupManager) Stats() (*Stats, error) {
+	if c.rootless {
+		if c.v2Path == "" {
+			return nil, fmt.Errorf("no cgroup to read resource usage from in rootless mode")
+		}
+		return statsV2(c.v2Path)
+	}
+	return statsV1(c.Path)
+}
+
+// cgroup v1：cpuacct.stat、memory.max_usage_in_bytes 和 memory.oom_control 中的 oom_kill
+func statsV1(cgroupPath string) (*Stats, error) {
+	var cpuacctDir, memoryDir string
+	if p, err := subsystem.GetCgroupPath("cpuacct", cgroupPath, false); err == nil {
+		cpuacctDir = p
+	}
+	if p, err := subsystem.GetCgroupPath("memory", cgroupPath, false); err == nil {
+		memoryDir = p
+	}
+	return readStatsV1(cpuacctDir, memoryDir)
+}
+
+// 从 cpuacct 和 memory 子系统的 cgroup 目录中读取统计，目录为空表示没有挂载这个子系统
+func readStatsV1(cpuacctDir, memoryDir string) (*Stats, error) {
+	stats := &Stats{}
+	if cpuacctDir != "" {
+		cpu, err := readKeyValues(path.Join(cpuacctDir, "cpuacct.stat"))
+		if err != nil {
+			return nil, err
+		}
+		stats.CpuUser = time.Duration(cpu["user"]) * time.Second / userHZ
+		stats.CpuSystem = time.Duration(cpu["system"]) * time.Second / userHZ
+	}
+	if memoryDir != "" {
+		maxUsage, err := readUint(path.Join(memoryDir, "memory.max_usage_in_bytes"))
+		if err != nil {
+			return nil, err
+		}
+		stats.MemoryMaxUsage = maxUsage
+		oom, err := readKeyValues(path.Join(memoryDir, "memory.oom_control"))
+		if err != nil {
+			return nil, err
+		}
+		stats.OomKills = oom["oom_kill"]
+	}
+	return stats, nil
+}
+
+// cgroup v2：cpu.stat、memory.peak 和 memory.events，memory.peak 在 5.19 之后的内核才有
+func statsV2(cgroupPath string) (*Stats, error) {
+	stats := &Stats{}
+	cpu, err := readKeyValues(path.Join(cgroupPath, "cpu.stat"))
+	if err != nil {
+		return nil, err
+	}
+	stats.CpuUser = time.Duration(cpu["user_usec"]) * time.Microsecond
+	stats.CpuSystem = time.Duration(cpu["system_usec"]) * time.Microsecond
+	if peak, err := readUint(path.Join(cgroupPath, "memory.peak")); err == nil {
+		stats.MemoryMaxUsage = peak
+	}
+	if events, err := readKeyValues(path.Join(cgroupPath, "memory.events")); err == nil {
+		stats.OomKills = events["oom_kill"]
+	}
+	return stats, nil
+}
+
+func readUint(file string) (uint64, error) {
+	bytes, err := ioutil.ReadFile(file)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseUint(strings.TrimSpace(string(bytes)), 10, 64)
+}
+
+// 读取每行为 key value 的统计文件，不是数字的行跳过
+func readKeyValues(file string) (map[string]uint64, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	values := map[string]uint64{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 2 {
+			continue
+		}
+		if n, err := strconv.ParseUint(fields[1], 10, 64); err == nil {
+			values[fields[0]] = n
+		}
+	}
+	return values, scanner.Err()
+}
@@ -0,0 +1,151 @@
+package cgroup
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// 在临时目录中写入 cgroup 统计文件
+func writeFixtures(t *testing.T, files map[string]string) string {
+	dir, err := ioutil.TempDir("", "mydocker-cgroup-stats")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range files {
+		if err := ioutil.WriteFile(path.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestReadKeyValues(t *testing.T) {
+	tests := []struct {
+		content string
+		want    map[string]uint64
+	}{
+		{"user 150\nsystem 25\n", map[string]uint64{"user": 150, "system": 25}},
+		{"oom_kill_disable 0\nunder_oom 0\noom_kill 2\n", map[string]uint64{"oom_kill_disable": 0, "under_oom": 0, "oom_kill": 2}},
+		// 字段数不是 2 或者值不是数字的行跳过
+		{"a 1 2\nb x\n\nc 3", map[string]uint64{"c": 3}},
+		{"", map[string]uint64{}},
+	}
+	for _, tt := range tests {
+		dir := writeFixtures(t, map[string]string{"stat": tt.content})
+		defer os.RemoveAll(dir)
+		got, err := readKeyValues(path.Join(dir, "stat"))
+		if err != nil {
+			t.Errorf("readKeyValues(%q) error %v", tt.content, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("readKeyValues(%q) = %v, want %v", tt.content, got, tt.want)
+		}
+	}
+}
+
+func TestReadStatsV1(t *testing.T) {
+	tests := []struct {
+		name    string
+		cpuacct map[string]string
+		memory  map[string]string
+		want    Stats
+		wantErr bool
+	}{
+		{
+			name:    "all subsystems",
+			cpuacct: map[string]string{"cpuacct.stat": "user 150\nsystem 25\n"},
+			memory: map[string]string{
+				"memory.max_usage_in_bytes": "10485760\n",
+				"memory.oom_control":        "oom_kill_disable 0\nunder_oom 0\noom_kill 1\n",
+			},
+			want: Stats{CpuUser: 1500 * time.Millisecond, CpuSystem: 250 * time.Millisecond, MemoryMaxUsage: 10 << 20, OomKills: 1},
+		},
+		{
+			// 较老的内核 memory.oom_control 中没有 oom_kill
+			name:    "no oom_kill",
+			cpuacct: map[string]string{"cpuacct.stat": "user 0\nsystem 1\n"},
+			memory: map[string]string{
+				"memory.max_usage_in_bytes": "4096",
+				"memory.oom_control":        "oom_kill_disable 0\nunder_oom 0\n",
+			},
+			want: Stats{CpuSystem: 10 * time.Millisecond, MemoryMaxUsage: 4096},
+		},
+		{
+			name:   "cpuacct not mounted",
+			memory: map[string]string{"memory.max_usage_in_bytes": "1", "memory.oom_control": ""},
+			want:   Stats{MemoryMaxUsage: 1},
+		},
+		{
+			name:    "missing max usage",
+			cpuacct: map[string]string{"cpuacct.stat": "user 1\nsystem 1\n"},
+			memory:  map[string]string{"memory.oom_control": "oom_kill 0\n"},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		var cpuacctDir, memoryDir string
+		if tt.cpuacct != nil {
+			cpuacctDir = writeFixtures(t, tt.cpuacct)
+			defer os.RemoveAll(cpuacctDir)
+		}
+		if tt.memory != nil {
+			memoryDir = writeFixtures(t, tt.memory)
+			defer os.RemoveAll(memoryDir)
+		}
+		got, err := readStatsV1(cpuacctDir, memoryDir)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: error = %v, wantErr %v", tt.name, err, tt.wantErr)
+			continue
+		}
+		if err == nil && *got != tt.want {
+			t.Errorf("%s: got %+v, want %+v", tt.name, *got, tt.want)
+		}
+	}
+}
+
+func TestStatsV2(t *testing.T) {
+	tests := []struct {
+		name    string
+		files   map[string]string
+		want    Stats
+		wantErr bool
+	}{
+		{
+			name: "all files",
+			files: map[string]string{
+				"cpu.stat":      "usage_usec 3500\nuser_usec 2000\nsystem_usec 1500\nnr_periods 0\n",
+				"memory.peak":   "8388608\n",
+				"memory.events": "low 0\nhigh 0\nmax 3\noom 1\noom_kill 1\n",
+			},
+			want: Stats{CpuUser: 2 * time.Millisecond, CpuSystem: 1500 * time.Microsecond, MemoryMaxUsage: 8 << 20, OomKills: 1},
+		},
+		{
+			// 5.19 之前的内核没有 memory.peak
+			name:  "no memory.peak",
+			files: map[string]string{"cpu.stat": "user_usec 10\nsystem_usec 20\n", "memory.events": "oom_kill 0\n"},
+			want:  Stats{CpuUser: 10 * time.Microsecond, CpuSystem: 20 * time.Microsecond},
+		},
+		{
+			name:    "missing cpu.stat",
+			files:   map[string]string{"memory.peak": "1"},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		dir := writeFixtures(t, tt.files)
+		defer os.RemoveAll(dir)
+		got, err := statsV2(dir)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: error = %v, wantErr %v", tt.name, err, tt.wantErr)
+			continue
+		}
+		if err == nil && *got != tt.want {
+			t.Errorf("%s: got %+v, want %+v", tt.name, *got, tt.want)
+		}
+	}
+}
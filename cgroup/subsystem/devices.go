@@ -0,0 +1,60 @@
+package subsystem
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"strconv"
+)
+
+// devices 子系统，只允许容器访问白名单中的设备
+type DevicesSubSystem struct {
+}
+
+func (s *DevicesSubSystem) Name() string {
+	return "devices"
+}
+
+// 先禁止所有设备，再逐条写入白名单，规则的格式为 "类型 主设备号:次设备号 权限"，例如 c 1:3 rwm
+func (s *DevicesSubSystem) Set(cgroupPath string, res *ResourceConfig) error {
+	if len(res.Devices) == 0 {
+		return nil
+	}
+	// cgroup v2 中没有 devices 子系统
+	if FindCgroupMountpoint(s.Name()) == "" {
+		return fmt.Errorf("devices cgroup is not mounted")
+	}
+	subsysCgroupPath, err := GetCgroupPath(s.Name(), cgroupPath, true)
+	if err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(path.Join(subsysCgroupPath, "devices.deny"), []byte("a"), 0644); err != nil {
+		return fmt.Errorf("set cgroup devices deny fail %v", err)
+	}
+	for _, rule := range res.Devices {
+		if err := ioutil.WriteFile(path.Join(subsysCgroupPath, "devices.allow"), []byte(rule), 0644); err != nil {
+			return fmt.Errorf("set cgroup devices allow %q fail %v", rule, err)
+		}
+	}
+	return nil
+}
+
+func (s *DevicesSubSystem) Apply(cgroupPath string, pid int) error {
+	if subsysCgroupPath, err := GetCgroupPath(s.Name(), cgroupPath, false); err == nil {
+		if err := ioutil.WriteFile(path.Join(subsysCgroupPath, "tasks"), []byte(strconv.Itoa(pid)), 0644); err != nil {
+			return fmt.Errorf("set cgroup proc fail %v", err)
+		}
+		return nil
+	} else {
+		return fmt.Errorf("get cgroup %s error: %v", cgroupPath, err)
+	}
+}
+
+func (s *DevicesSubSystem) Remove(cgroupPath string) error {
+	if subsysCgroupPath, err := GetCgroupPath(s.Name(), cgroupPath, false); err == nil {
+		return os.RemoveAll(subsysCgroupPath)
+	} else {
+		return err
+	}
+}
@@ -2,11 +2,15 @@ package subsystem
 
 // 用于传递资源限制配置的结构体
 // subsystem 作为资源控制模块，可以限制的资源类型可以通过 lssubsys -a 命令进行查看
-// 这里只限制以下 3 种资源类型
+// 这里限制内存、cpu、cpuset、设备和进程数，cpuacct 只用于统计，ulimit 和 oom_score_adj 不属于 cgroup，但同样随容器信息保存
 type ResourceConfig struct {
-	MemoryLimit string //内存限制
-	CpuShare string    //cpu 时间片权重
-	CpuSet string      //cpu 核心数
+	MemoryLimit string   `json:"memoryLimit"` //内存限制
+	CpuShare    string   `json:"cpuShare"`    //cpu 时间片权重
+	CpuSet      string   `json:"cpuSet"`      //cpu 核心数
+	Devices     []string `json:"devices"`     //devices 子系统的白名单规则
+	PidsLimit   string   `json:"pidsLimit"`   //最大进程数，max 表示不限制
+	Ulimits     []string `json:"ulimits"`     //容器进程的资源限制，例如 nofile=1024:2048，由 init 进程通过 setrlimit 设置
+	OomScoreAdj int      `json:"oomScoreAdj"` //容器进程的 oom_score_adj，取值范围 [-1000, 1000]
 }
 
 // 这里将 cgroup 抽象成 path
@@ -14,7 +18,7 @@ type Subsystem interface {
 	// 返回 subsystem 的名字
 	Name() string
 	//
-	Set(path string, res * ResourceConfig) error
+	Set(path string, res *ResourceConfig) error
 	// 将进程添加到某个 cgroup 中
 	Apply(path string, pid int) error
 	// 移除 cgroup
@@ -27,5 +31,8 @@ var (
 		&CpusetSubSystem{},
 		&MemorySubSystem{},
 		&CpuSubSystem{},
+		&CpuacctSubSystem{},
+		&PidsSubSystem{},
+		&DevicesSubSystem{},
 	}
-)
\ No newline at end of file
+)
@@ -0,0 +1,300 @@
+package container
+
+import (
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"unsafe"
+)
+
+// 内核中各个 capability 的编号，见 linux/capability.h
+var capabilityList = []string{
+	"CAP_CHOWN",
+	"CAP_DAC_OVERRIDE",
+	"CAP_DAC_READ_SEARCH",
+	"CAP_FOWNER",
+	"CAP_FSETID",
+	"CAP_KILL",
+	"CAP_SETGID",
+	"CAP_SETUID",
+	"CAP_SETPCAP",
+	"CAP_LINUX_IMMUTABLE",
+	"CAP_NET_BIND_SERVICE",
+	"CAP_NET_BROADCAST",
+	"CAP_NET_ADMIN",
+	"CAP_NET_RAW",
+	"CAP_IPC_LOCK",
+	"CAP_IPC_OWNER",
+	"CAP_SYS_MODULE",
+	"CAP_SYS_RAWIO",
+	"CAP_SYS_CHROOT",
+	"CAP_SYS_PTRACE",
+	"CAP_SYS_PACCT",
+	"CAP_SYS_ADMIN",
+	"CAP_SYS_BOOT",
+	"CAP_SYS_NICE",
+	"CAP_SYS_RESOURCE",
+	"CAP_SYS_TIME",
+	"CAP_SYS_TTY_CONFIG",
+	"CAP_MKNOD",
+	"CAP_LEASE",
+	"CAP_AUDIT_WRITE",
+	"CAP_AUDIT_CONTROL",
+	"CAP_SETFCAP",
+	"CAP_MAC_OVERRIDE",
+	"CAP_MAC_ADMIN",
+	"CAP_SYSLOG",
+	"CAP_WAKE_ALARM",
+	"CAP_BLOCK_SUSPEND",
+	"CAP_AUDIT_READ",
+	"CAP_PERFMON",
+	"CAP_BPF",
+	"CAP_CHECKPOINT_RESTORE",
+}
+
+// 容器默认保留的 capability，与 docker 的默认值一致
+var DefaultCapabilities = []string{
+	"CAP_CHOWN",
+	"CAP_DAC_OVERRIDE",
+	"CAP_FSETID",
+	"CAP_FOWNER",
+	"CAP_MKNOD",
+	"CAP_NET_RAW",
+	"CAP_SETGID",
+	"CAP_SETUID",
+	"CAP_SETFCAP",
+	"CAP_SETPCAP",
+	"CAP_NET_BIND_SERVICE",
+	"CAP_SYS_CHROOT",
+	"CAP_KILL",
+	"CAP_AUDIT_WRITE",
+}
+
+// --cap-add、--cap-drop 中表示全部 capability 的取值
+const CapabilityAll = "ALL"
+
+// 所有已知的 capability
+func AllCapabilities() []string {
+	return append([]string(nil), capabilityList...)
+}
+
+// 把 chown、cap_chown、CAP_CHOWN 统一为 CAP_CHOWN 的形式，未知的名字返回错误
+func normalizeCapability(name string) (string, error) {
+	capName := strings.ToUpper(strings.TrimSpace(name))
+	if !strings.HasPrefix(capName, "CAP_") {
+		capName = "CAP_" + capName
+	}
+	for _, c := range capabilityList {
+		if c == capName {
+			return capName, nil
+		}
+	}
+	return "", fmt.Errorf("unknown capability %q", name)
+}
+
+func capabilityIndex(name string) int {
+	for i, c := range capabilityList {
+		if c == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// 在 base 的基础上计算最终的 capability 集合：
+// 1.--cap-drop 为 ALL 时清空，否则去掉指定的 capability
+// 2.--cap-add 为 ALL 时包含全部，否则加上指定的 capability，同时出现在两者中时以 --cap-add 为准
+func ResolveCapabilities(base, capAdd, capDrop []string) ([]string, error) {
+	set := map[string]bool{}
+	for _, c := range base {
+		set[c] = true
+	}
+	for _, c := range capDrop {
+		if strings.ToUpper(c) == CapabilityAll {
+			set = map[string]bool{}
+			continue
+		}
+		capName, err := normalizeCapability(c)
+		if err != nil {
+			return nil, err
+		}
+		delete(set, capName)
+	}
+	for _, c := range capAdd {
+		if strings.ToUpper(c) == CapabilityAll {
+			for _, all := range capabilityList {
+				set[all] = true
+			}
+			continue
+		}
+		capName, err := normalizeCapability(c)
+		if err != nil {
+			return nil, err
+		}
+		set[capName] = true
+	}
+	caps := []string{}
+	for c := range set {
+		caps = append(caps, c)
+	}
+	sort.Slice(caps, func(i, j int) bool { return capabilityIndex(caps[i]) < capabilityIndex(caps[j]) })
+	return caps, nil
+}
+
+// 当前内核支持的最大 capability 编号，比它大的 capability 跳过
+func lastCap() int {
+	bytes, err := ioutil.ReadFile("/proc/sys/kernel/cap_last_cap")
+	if err != nil {
+		return len(capabilityList) - 1
+	}
+	last, err := strconv.Atoi(strings.TrimSpace(string(bytes)))
+	if err != nil {
+		return len(capabilityList) - 1
+	}
+	return last
+}
+
+// prctl 相关的常量，见 linux/prctl.h
+const (
+	prCapbsetDrop           = 24
+	prSetKeepcaps           = 8
+	prCapAmbient            = 47
+	prCapAmbientRaise       = 2
+	prCapAmbientClearAll    = 4
+	linuxCapabilityVersion3 = 0x20080522
+)
+
+type capUserHeader struct {
+	version uint32
+	pid     int32
+}
+
+type capUserData struct {
+	effective   uint32
+	permitted   uint32
+	inheritable uint32
+}
+
+func prctl(option int, arg2 uintptr) error {
+	if _, _, errno := syscall.RawSyscall6(syscall.SYS_PRCTL, uintptr(option), arg2, 0, 0, 0, 0); errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// capability 是线程级别的属性，调用方需要先 runtime.LockOSThread()，保证在同一个线程上 exec
+// 切换用户之前调用：从 bounding 集合中去掉不需要的 capability，并保证 setuid 之后不丢失 capability
+// 去掉 bounding 集合中的 capability 需要 CAP_SETPCAP，所以必须在切换用户之前完成
+func dropBoundingCapabilities(caps []string) error {
+	keep := map[int]bool{}
+	for _, c := range caps {
+		keep[capabilityIndex(c)] = true
+	}
+	for i := 0; i <= lastCap(); i++ {
+		if keep[i] {
+			continue
+		}
+		if err := prctl(prCapbsetDrop, uintptr(i)); err != nil {
+			return fmt.Errorf("drop bounding capability %d error %v", i, err)
+		}
+	}
+	return prctl(prSetKeepcaps, 1)
+}
+
+// --cap-add 指定并且最终保留下来的 capability，非 root 用户只会在 ambient 集合中获得这些 capability
+func AmbientCapabilities(caps, capAdd []string) []string {
+	keep := map[string]bool{}
+	for _, c := range caps {
+		keep[c] = true
+	}
+	ambient := []string{}
+	for _, c := range capAdd {
+		if strings.ToUpper(c) == CapabilityAll {
+			return append([]string{}, caps...)
+		}
+		capName, err := normalizeCapability(c)
+		if err != nil || !keep[capName] {
+			continue
+		}
+		keep[capName] = false
+		ambient = append(ambient, capName)
+	}
+	return ambient
+}
+
+// 进程 exec 之前的各个 capability 集合，第 i 位表示 capabilityList[i]
+type capabilitySets struct {
+	effective   uint64
+	permitted   uint64
+	inheritable uint64
+	ambient     uint64
+}
+
+func capabilityMask(caps []string, last int) uint64 {
+	var mask uint64
+	for _, c := range caps {
+		if i := capabilityIndex(c); i >= 0 && i <= last {
+			mask |= 1 << uint(i)
+		}
+	}
+	return mask
+}
+
+// 根据用户计算各个 capability 集合：
+// 1.root 用户：effective、permitted、inheritable 都是 caps，exec 之后仍然拥有这些 capability，不需要 ambient
+// 2.非 root 用户：caps 只保留在 bounding 和 inheritable 中，只有 --cap-add 指定的 capability 通过 ambient 在 exec 之后生效
+// ambient 要求 capability 同时在 permitted 和 inheritable 中，所以非 root 用户的 effective、permitted 只包含这部分
+func resolveCapabilitySets(caps, ambient []string, uid, last int) capabilitySets {
+	mask := capabilityMask(caps, last)
+	if uid == 0 {
+		return capabilitySets{effective: mask, permitted: mask, inheritable: mask}
+	}
+	ambientMask := capabilityMask(ambient, last) & mask
+	return capabilitySets{effective: ambientMask, permitted: ambientMask, inheritable: mask, ambient: ambientMask}
+}
+
+// 切换用户之后调用：按照 uid 设置 effective、permitted、inheritable 和 ambient 集合
+func applyCapabilities(caps, ambient []string, uid int) error {
+	last := lastCap()
+	sets := resolveCapabilitySets(caps, ambient, uid, last)
+	// 当前进程本身就没有的 capability（例如宿主机上运行 mydocker 时已经被限制）无法再获得，跳过
+	var current [2]capUserData
+	header := capUserHeader{version: linuxCapabilityVersion3}
+	if _, _, errno := syscall.RawSyscall(syscall.SYS_CAPGET, uintptr(unsafe.Pointer(&header)), uintptr(unsafe.Pointer(&current[0])), 0); errno != 0 {
+		return fmt.Errorf("capget error %v", errno)
+	}
+	available := uint64(current[0].permitted) | uint64(current[1].permitted)<<32
+	sets.effective &= available
+	sets.permitted &= available
+	sets.inheritable &= available
+	sets.ambient &= available
+	var data [2]capUserData
+	for i := range data {
+		shift := uint(32 * i)
+		data[i].effective = uint32(sets.effective >> shift)
+		data[i].permitted = uint32(sets.permitted >> shift)
+		data[i].inheritable = uint32(sets.inheritable >> shift)
+	}
+	if _, _, errno := syscall.RawSyscall(syscall.SYS_CAPSET, uintptr(unsafe.Pointer(&header)), uintptr(unsafe.Pointer(&data[0])), 0); errno != 0 {
+		return fmt.Errorf("capset error %v", errno)
+	}
+	if err := prctl(prSetKeepcaps, 0); err != nil {
+		return err
+	}
+	// 较老的内核不支持 ambient 集合，忽略错误
+	if err := prctl(prCapAmbient, prCapAmbientClearAll); err != nil {
+		return nil
+	}
+	for i := 0; i <= last; i++ {
+		if sets.ambient&(1<<uint(i)) == 0 {
+			continue
+		}
+		if _, _, errno := syscall.RawSyscall6(syscall.SYS_PRCTL, prCapAmbient, prCapAmbientRaise, uintptr(i), 0, 0, 0); errno != 0 {
+			return fmt.Errorf("raise ambient capability %s error %v", capabilityList[i], errno)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,81 @@
+package container
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestResolveCapabilities(t *testing.T) {
+	tests := []struct {
+		base    []string
+		capAdd  []string
+		capDrop []string
+		want    []string
+	}{
+		{[]string{"CAP_CHOWN", "CAP_KILL"}, nil, nil, []string{"CAP_CHOWN", "CAP_KILL"}},
+		{[]string{"CAP_CHOWN", "CAP_KILL"}, []string{"net_admin"}, []string{"CAP_chown"}, []string{"CAP_KILL", "CAP_NET_ADMIN"}},
+		{[]string{"CAP_CHOWN", "CAP_KILL"}, []string{"KILL"}, []string{"ALL"}, []string{"CAP_KILL"}},
+		{[]string{"CAP_CHOWN"}, []string{"SYS_ADMIN"}, []string{"SYS_ADMIN"}, []string{"CAP_CHOWN", "CAP_SYS_ADMIN"}},
+		{nil, nil, []string{"ALL"}, []string{}},
+	}
+	for _, test := range tests {
+		got, err := ResolveCapabilities(test.base, test.capAdd, test.capDrop)
+		if err != nil {
+			t.Fatalf("ResolveCapabilities(%v, %v, %v) error %v", test.base, test.capAdd, test.capDrop, err)
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("ResolveCapabilities(%v, %v, %v) = %v, want %v", test.base, test.capAdd, test.capDrop, got, test.want)
+		}
+	}
+
+	all, err := ResolveCapabilities(nil, []string{"all"}, nil)
+	if err != nil || !reflect.DeepEqual(all, AllCapabilities()) {
+		t.Errorf("ResolveCapabilities with ALL = %v, %v", all, err)
+	}
+	if _, err := ResolveCapabilities(DefaultCapabilities, []string{"NOT_A_CAP"}, nil); err == nil {
+		t.Errorf("expected error for unknown capability")
+	}
+}
+
+func TestAmbientCapabilities(t *testing.T) {
+	caps := []string{"CAP_CHOWN", "CAP_NET_BIND_SERVICE", "CAP_NET_ADMIN"}
+	tests := []struct {
+		capAdd []string
+		want   []string
+	}{
+		{nil, []string{}},
+		{[]string{"net_admin"}, []string{"CAP_NET_ADMIN"}},
+		// 被 --cap-drop 去掉的不会进入 ambient
+		{[]string{"SYS_ADMIN", "NET_ADMIN"}, []string{"CAP_NET_ADMIN"}},
+		{[]string{"ALL"}, caps},
+	}
+	for _, tt := range tests {
+		got := AmbientCapabilities(caps, tt.capAdd)
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("AmbientCapabilities(%v) = %v, want %v", tt.capAdd, got, tt.want)
+		}
+	}
+}
+
+func TestResolveCapabilitySets(t *testing.T) {
+	caps := []string{"CAP_CHOWN", "CAP_KILL", "CAP_NET_ADMIN"}
+	mask := uint64(1<<0 | 1<<5 | 1<<12)
+	tests := []struct {
+		uid     int
+		ambient []string
+		want    capabilitySets
+	}{
+		{0, nil, capabilitySets{effective: mask, permitted: mask, inheritable: mask}},
+		{0, []string{"CAP_NET_ADMIN"}, capabilitySets{effective: mask, permitted: mask, inheritable: mask}},
+		{1000, nil, capabilitySets{inheritable: mask}},
+		{1000, []string{"CAP_NET_ADMIN"}, capabilitySets{effective: 1 << 12, permitted: 1 << 12, inheritable: mask, ambient: 1 << 12}},
+		// 不在 caps 中的 capability 不能进入 ambient
+		{1000, []string{"CAP_SYS_ADMIN"}, capabilitySets{inheritable: mask}},
+	}
+	for _, tt := range tests {
+		got := resolveCapabilitySets(caps, tt.ambient, tt.uid, 40)
+		if got != tt.want {
+			t.Errorf("resolveCapabilitySets(uid %d, ambient %v) = %+v, want %+v", tt.uid, tt.ambient, got, tt.want)
+		}
+	}
+}
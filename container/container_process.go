@@ -3,9 +3,11 @@ package container
 import (
 	"fmt"
 	"github.com/Sirupsen/logrus"
+	"github.com/kkBill/mydocker/cgroup/subsystem"
 	"os"
 	"os/exec"
 	"syscall"
+	"time"
 )
 
 // version 1 2019-11-29
@@ -34,20 +36,78 @@ var (
 	DefaultInfoLocation string = "/var/run/mydocker/%s/"
 	ConfigName          string = "config.json"
 	ContainerLogFile    string = "container.log"
+	MonitorLogFile      string = "monitor.log"
+	AttachSocket        string = "attach.sock"
 	RootUrl             string = "/root"
 	MntUrl              string = "/root/mnt/%s"
 	WriteLayerUrl       string = "/root/writeLayer/%s"
+	WorkLayerUrl        string = "/root/workLayer/%s" //overlay 的 workdir，只在 rootless 模式下使用
 )
 
 type ContainerInfo struct {
-	Pid         string `json:"pid"`        //容器的init进程在宿主机上的 PID
-	Id          string `json:"id"`         //容器Id
-	Name        string `json:"name"`       //容器名
-	Command     string `json:"command"`    //容器内init运行命令
-	CreatedTime string `json:"createdTime"` //创建时间
-	Status      string `json:"status"`     //容器的状态
-	Volume      string `json:"volume"`     //容器的数据卷
-	PortMapping []string `json:"portmapping"` //端口映射
+	Pid          string                    `json:"pid"`               //容器的init进程在宿主机上的 PID
+	Id           string                    `json:"id"`                //容器Id
+	Name         string                    `json:"name"`              //容器名
+	Command      string                    `json:"command"`           //容器内init运行命令
+	CreatedTime  string                    `json:"createdTime"`       //创建时间
+	Status       string                    `json:"status"`            //容器的状态
+	Volume       string                    `json:"volume"`            //容器的数据卷
+	PortMapping  []string                  `json:"portmapping"`       //端口映射
+	Tty          bool                      `json:"tty"`               //是否分配了终端
+	Interactive  bool                      `json:"interactive"`       //是否保持标准输入打开
+	Detach       bool                      `json:"detach"`            //是否后台运行
+	LogDriver    string                    `json:"logDriver"`         //日志驱动，json-file、syslog 或 none
+	LogOpts      map[string]string         `json:"logOpts"`           //日志驱动的选项，例如 max-size、max-file
+	Hostname     string                    `json:"hostname"`          //容器的主机名
+	Domainname   string                    `json:"domainname"`        //容器的域名
+	ExtraHosts   []string                  `json:"extraHosts"`        //额外写入 hosts 文件的 name:ip
+	Dns          []string                  `json:"dns"`               //DNS 服务器
+	DnsSearch    []string                  `json:"dnsSearch"`         //DNS 搜索域
+	IPAddress    string                    `json:"ipAddress"`         //容器在网络中分配到的 IP
+	WorkingDir   string                    `json:"workingDir"`        //容器进程的工作目录
+	User         string                    `json:"user"`              //容器进程的用户，name|uid[:group|gid]
+	Userns       *UsernsConfig             `json:"userns"`            //user namespace 的配置
+	Privileged   bool                      `json:"privileged"`        //特权容器，保留所有 capability
+	CapAdd       []string                  `json:"capAdd"`            //--cap-add 指定的 capability
+	CapDrop      []string                  `json:"capDrop"`           //--cap-drop 指定的 capability
+	Capabilities []string                  `json:"capabilities"`      //容器进程最终保留的 capability
+	SecurityOpt  []string                  `json:"securityOpt"`       //--security-opt 指定的安全选项
+	Seccomp      string                    `json:"seccomp"`           //seccomp 配置，default、unconfined 或配置文件的路径
+	Landlock     string                    `json:"landlock"`          //landlock 配置文件的路径，为空表示不使用 landlock
+	ReadOnly     bool                      `json:"readOnly"`          //根目录只读
+	Devices      []*Device                 `json:"devices"`           //--device 指定的设备
+	ShmSize      int64                     `json:"shmSize"`           //共享内存 /dev/shm 的大小，单位为字节
+	Resources    *subsystem.ResourceConfig `json:"resources"`         //cgroup 资源限制、ulimit 和 oom_score_adj
+	Sysctls      map[string]string         `json:"sysctls"`           //--sysctl 指定的内核参数
+	Namespaces   *NamespaceConfig          `json:"namespaces"`        //与宿主机或其他容器共享的 namespace
+	Pod          string                    `json:"pod"`               //容器所属的 pod，为空表示不属于任何 pod
+	TimeOffsets  *TimeOffsets              `json:"timeOffsets"`       //time namespace 的时钟偏移，为空表示不创建 time namespace
+	Init         bool                      `json:"init"`              //由 mydocker 作为容器的 1 号进程运行用户命令
+	Timeout      time.Duration             `json:"timeout"`           //--timeout，超过这个时间停止容器，0 表示不限制
+	Report       bool                      `json:"report"`            //--report，容器退出后统计资源使用情况
+	ExitCode     int                       `json:"exitCode"`          //容器 init 进程的退出码，被信号杀死时为 128+信号
+	ExitReason   string                    `json:"exitReason"`        //退出原因，timeout 表示超时被停止
+	Summary      *Report                   `json:"summary,omitempty"` //--report 生成的汇总
+}
+
+// 容器进程的标准输入输出在父进程（run 或 monitor 进程）中的一端
+type ProcessIO struct {
+	Console *os.File // tty 模式下 pty 的 master 端
+	Stdin   *os.File // -i 时写入容器标准输入的一端，tty 模式下就是 Console，没有 -i 时为空
+	Stdout  *os.File // 非 tty 模式下容器标准输出管道的读端
+	Stderr  *os.File // 非 tty 模式下容器标准错误管道的读端
+
+	// 交给容器进程的一端，容器进程启动后父进程需要关闭它们
+	childFiles []*os.File
+}
+
+// 关闭父进程中持有的、已经交给容器进程的那一端
+// 对于 pty 来说，只有所有 slave 端都关闭后，读 master 端才会在容器退出时返回
+func (pio *ProcessIO) CloseAfterStart() {
+	for _, f := range pio.childFiles {
+		f.Close()
+	}
+	pio.childFiles = nil
 }
 
 // version 2 2019-12-02
@@ -61,46 +121,54 @@ func NewPipe() (*os.File, *os.File, error) {
 }
 
 // 这个函数不太理解(2019-12-05)
-func NewParentProcess(tty bool, volume, containerName, imageName string) (*exec.Cmd, *os.File) {
+func NewParentProcess(tty, interactive bool, volume, containerName, imageName string, userns *UsernsConfig, namespaces *NamespaceConfig, timeOffsets *TimeOffsets) (*exec.Cmd, *os.File, *ProcessIO) {
 	readPipe, writePipe, err := NewPipe()
 	if err != nil {
 		logrus.Errorf("New pipe error %v", err)
-		return nil, nil
+		return nil, nil, nil
 	}
 
 	// 初始化容器，执行自己定义的 init 命令
 	cmd := exec.Command("/proc/self/exe", "init")
 
 	//noinspection ALL
+	// cgroup namespace 以创建时所在的 cgroup 为根，要等容器进程加入自己的 cgroup 之后再由 init 进程创建
 	cmd.SysProcAttr = &syscall.SysProcAttr{
-		Cloneflags: syscall.CLONE_NEWUTS | syscall.CLONE_NEWIPC | syscall.CLONE_NEWPID | syscall.CLONE_NEWNS | syscall.CLONE_NEWUSER | syscall.CLONE_NEWNET,
+		Cloneflags: namespaces.CloneFlags() &^ cloneNewCgroup,
+	}
+	cmd.Env = os.Environ()
+	// time namespace 不能通过 clone 创建，由 init 进程在 go 运行时启动之前创建并写入偏移
+	if timeOffsets != nil {
+		cmd.Env = append(cmd.Env, ENV_TIME_OFFSETS+"="+timeOffsets.procFormat())
+	}
+	switch {
+	case userns.Host:
+		// --userns=host 不创建 user namespace
+	case userns.NeedNewIDMap():
+		// 由父进程在容器进程启动后调用 newuidmap/newgidmap 写入映射
+		cmd.SysProcAttr.Cloneflags |= syscall.CLONE_NEWUSER
+		cmd.Env = append(cmd.Env, ENV_USERNS_SYNC+"=1")
+	default:
+		cmd.SysProcAttr.Cloneflags |= syscall.CLONE_NEWUSER
 		// 关键！！！
-		UidMappings: []syscall.SysProcIDMap{{ContainerID: 0, HostID: syscall.Getuid(), Size: 1,},},
-		GidMappings: []syscall.SysProcIDMap{{ContainerID: 0, HostID: syscall.Getgid(), Size: 1,},},
+		cmd.SysProcAttr.UidMappings = userns.UidMappings
+		cmd.SysProcAttr.GidMappings = userns.GidMappings
+		// 允许容器内调用 setgroups，-u 切换用户时需要设置附加组
+		// 普通用户写 gid_map 之前内核要求先禁用 setgroups
+		cmd.SysProcAttr.GidMappingsEnableSetgroups = !IsRootless()
+		// 使用从属 id 时宿主机上当前的 uid 在容器内没有映射，需要先切换为容器内的 root 再执行 init，否则 exec 之后没有 capability
+		if userns.Remap != "" {
+			cmd.SysProcAttr.Credential = &syscall.Credential{Uid: 0, Gid: 0}
+		}
 	}
 	//cmd.SysProcAttr.Credential = &syscall.Credential{Uid: uint32(1), Gid: uint32(1)}
-	// 如果开启终端，读入终端的输入
-	//cmd.Stdin = os.Stdin
-	if tty {
-		cmd.Stdin = os.Stdin
-		cmd.Stdout = os.Stdout
-		cmd.Stderr = os.Stderr
-	}else{
-		// 生成容器对应目录的container.log文件
-		path := fmt.Sprintf(DefaultInfoLocation, containerName)
-		if err := os.MkdirAll(path, 0622); err != nil {
-			logrus.Errorf("NewParentProcess: mkdir %s error %v.", path, err)
-			return nil, nil
-		}
-		logFilePath := path + ContainerLogFile
-		logrus.Infof("container.log path: %v", logFilePath)
-		logFile, err := os.Create(logFilePath)
-		if err != nil {
-			logrus.Errorf("NewParentProcess: create %s error %v.", logFilePath, err)
-			return nil, nil
-		}
-		// 把生成好的文件赋值给stdout，把容器内的标准输出重定向到该文件中
-		cmd.Stdout = logFile
+	// 如果开启终端，为容器分配一个 pty，slave 端作为容器进程的标准输入输出
+	// 否则把容器的标准输出和标准错误接到管道上，由父进程负责写日志和转发给 attach 的客户端
+	// -i 时保持容器的标准输入打开，没有分配终端时标准输入同样接到管道上
+	pio, err := newProcessIO(cmd, tty, interactive)
+	if err != nil {
+		logrus.Errorf("NewParentProcess: create process io error %v.", err)
+		return nil, nil, nil
 	}
 
 	// ExtraFiles specifies additional open files to be inherited by the
@@ -110,8 +178,68 @@ func NewParentProcess(tty bool, volume, containerName, imageName string) (*exec.
 
 	//mntURL := "/root/mnt/"
 	//rootURL := "/root/"
-	NewWorkSpace(volume, imageName, containerName)
+	NewWorkSpace(volume, imageName, containerName, userns)
+	if userns.Remap != "" && !IsRootless() {
+		stateDir := fmt.Sprintf(DefaultInfoLocation, containerName)
+		if err := os.MkdirAll(stateDir, 0700); err != nil {
+			logrus.Errorf("NewParentProcess: mkdir %s error %v", stateDir, err)
+		}
+		for _, dir := range []string{fmt.Sprintf(MntUrl, containerName), stateDir} {
+			if err := AllowTraverse(dir); err != nil {
+				logrus.Errorf("NewParentProcess: allow traverse %s error %v", dir, err)
+			}
+		}
+	}
 	// Dir specifies the working directory of the command.
 	cmd.Dir = fmt.Sprintf(MntUrl, containerName)
-	return cmd, writePipe
+	return cmd, writePipe, pio
+}
+
+func newProcessIO(cmd *exec.Cmd, tty, interactive bool) (*ProcessIO, error) {
+	pio := &ProcessIO{}
+	if tty {
+		master, slave, err := NewPty()
+		if err != nil {
+			return nil, err
+		}
+		cmd.Stdin = slave
+		cmd.Stdout = slave
+		cmd.Stderr = slave
+		// 容器进程创建新的会话，并把 slave 端（子进程中的 0 号文件描述符）设置为控制终端
+		cmd.SysProcAttr.Setsid = true
+		cmd.SysProcAttr.Setctty = true
+		cmd.SysProcAttr.Ctty = 0
+		pio.Console = master
+		if interactive {
+			pio.Stdin = master
+		}
+		pio.childFiles = []*os.File{slave}
+		return pio, nil
+	}
+	// 没有 -i 时容器的标准输入为 /dev/null
+	if interactive {
+		stdinRead, stdinWrite, err := NewPipe()
+		if err != nil {
+			return nil, err
+		}
+		cmd.Stdin = stdinRead
+		pio.Stdin = stdinWrite
+		pio.childFiles = append(pio.childFiles, stdinRead)
+	}
+	stdoutRead, stdoutWrite, err := NewPipe()
+	if err != nil {
+		return nil, err
+	}
+	stderrRead, stderrWrite, err := NewPipe()
+	if err != nil {
+		stdoutRead.Close()
+		stdoutWrite.Close()
+		return nil, err
+	}
+	cmd.Stdout = stdoutWrite
+	cmd.Stderr = stderrWrite
+	pio.Stdout = stdoutRead
+	pio.Stderr = stderrRead
+	pio.childFiles = append(pio.childFiles, stdoutWrite, stderrWrite)
+	return pio, nil
 }
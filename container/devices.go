@@ -0,0 +1,128 @@
+package container
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+)
+
+// 容器中的设备文件
+type Device struct {
+	PathOnHost      string      `json:"pathOnHost"`      //宿主机上的路径
+	PathInContainer string      `json:"pathInContainer"` //容器中的路径
+	Permissions     string      `json:"permissions"`     //devices cgroup 中的权限，r、w、m 的组合
+	Type            string      `json:"type"`            //c 表示字符设备，b 表示块设备
+	Major           int64       `json:"major"`
+	Minor           int64       `json:"minor"`
+	FileMode        os.FileMode `json:"fileMode"`
+}
+
+// 每个容器都有的设备
+var DefaultDevices = []*Device{
+	{PathOnHost: "/dev/null", PathInContainer: "/dev/null", Permissions: "rwm", Type: "c", Major: 1, Minor: 3, FileMode: 0666},
+	{PathOnHost: "/dev/zero", PathInContainer: "/dev/zero", Permissions: "rwm", Type: "c", Major: 1, Minor: 5, FileMode: 0666},
+	{PathOnHost: "/dev/full", PathInContainer: "/dev/full", Permissions: "rwm", Type: "c", Major: 1, Minor: 7, FileMode: 0666},
+	{PathOnHost: "/dev/random", PathInContainer: "/dev/random", Permissions: "rwm", Type: "c", Major: 1, Minor: 8, FileMode: 0666},
+	{PathOnHost: "/dev/urandom", PathInContainer: "/dev/urandom", Permissions: "rwm", Type: "c", Major: 1, Minor: 9, FileMode: 0666},
+	{PathOnHost: "/dev/tty", PathInContainer: "/dev/tty", Permissions: "rwm", Type: "c", Major: 5, Minor: 0, FileMode: 0666},
+}
+
+// devices cgroup 中除了设备文件之外默认允许的规则：
+// 允许创建任意设备文件（但不能读写），以及读写伪终端和 /dev/net/tun
+var defaultDeviceRules = []string{
+	"c *:* m",
+	"b *:* m",
+	"c 136:* rwm",
+	"c 5:2 rwm",
+	"c 10:200 rwm",
+}
+
+// /dev/shm 默认的大小
+const DefaultShmSize = 64 << 20
+
+// 解析 --device /dev/host[:/dev/ctr[:perms]]，读取宿主机上设备文件的类型和设备号
+func ParseDevice(spec string) (*Device, error) {
+	parts := strings.Split(spec, ":")
+	if len(parts) > 3 || parts[0] == "" {
+		return nil, fmt.Errorf("invalid device %q, must be /dev/host[:/dev/container[:rwm]]", spec)
+	}
+	device := &Device{PathOnHost: parts[0], PathInContainer: parts[0], Permissions: "rwm"}
+	if len(parts) > 1 && parts[1] != "" {
+		device.PathInContainer = parts[1]
+	}
+	if len(parts) > 2 {
+		device.Permissions = parts[2]
+	}
+	if !validDevicePath(device.PathInContainer) {
+		return nil, fmt.Errorf("invalid device %q, container path must be a clean path under /dev/", spec)
+	}
+	if device.Permissions == "" || strings.Trim(device.Permissions, "rwm") != "" {
+		return nil, fmt.Errorf("invalid device permissions %q, must be a combination of r, w and m", device.Permissions)
+	}
+
+	var stat syscall.Stat_t
+	if err := syscall.Stat(device.PathOnHost, &stat); err != nil {
+		return nil, fmt.Errorf("stat device %s error %v", device.PathOnHost, err)
+	}
+	switch stat.Mode & syscall.S_IFMT {
+	case syscall.S_IFCHR:
+		device.Type = "c"
+	case syscall.S_IFBLK:
+		device.Type = "b"
+	default:
+		return nil, fmt.Errorf("%s is not a device", device.PathOnHost)
+	}
+	rdev := uint64(stat.Rdev)
+	device.Major = int64((rdev>>8)&0xfff | (rdev>>32)&^0xfff)
+	device.Minor = int64(rdev&0xff | (rdev>>12)&^0xff)
+	device.FileMode = os.FileMode(stat.Mode & 07777)
+	return device, nil
+}
+
+// 容器中的设备文件只能放在 /dev 下：/dev 是容器自己的 tmpfs，其中没有镜像中的符号链接，
+// 路径中也不能有 ..，否则设备文件会被创建到 rootfs 之外
+func validDevicePath(p string) bool {
+	return filepath.Clean(p) == p && strings.HasPrefix(p, "/dev/") && !strings.Contains(p, "..")
+}
+
+// 生成 devices cgroup 的白名单，特权容器可以访问所有设备
+func DeviceCgroupRules(devices []*Device, privileged bool) []string {
+	if privileged {
+		return []string{"a *:* rwm"}
+	}
+	rules := append([]string(nil), defaultDeviceRules...)
+	for _, d := range append(append([]*Device(nil), DefaultDevices...), devices...) {
+		rules = append(rules, fmt.Sprintf("%s %d:%d %s", d.Type, d.Major, d.Minor, d.Permissions))
+	}
+	return rules
+}
+
+// 在容器的 /dev 中创建设备文件，devDir 是已经在 rootfs 中解析好的 /dev 目录
+// user namespace 中没有权限 mknod 时从宿主机 bind mount
+func createDevice(devDir string, device *Device) error {
+	if !validDevicePath(device.PathInContainer) {
+		return fmt.Errorf("invalid device path %s", device.PathInContainer)
+	}
+	target := filepath.Join(devDir, strings.TrimPrefix(device.PathInContainer, "/dev/"))
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return err
+	}
+	mode := uint32(device.FileMode)
+	if device.Type == "b" {
+		mode |= syscall.S_IFBLK
+	} else {
+		mode |= syscall.S_IFCHR
+	}
+	os.Remove(target)
+	dev := int((device.Major&0xfff)<<8 | device.Minor&0xff | (device.Minor&^0xff)<<12 | (device.Major&^0xfff)<<32)
+	if err := syscall.Mknod(target, mode, dev); err != nil {
+		if err != syscall.EPERM {
+			return fmt.Errorf("mknod %s error %v", target, err)
+		}
+		return bindDevice(device.PathOnHost, target)
+	}
+	// mknod 受 umask 影响，重新设置权限
+	return os.Chmod(target, device.FileMode)
+}
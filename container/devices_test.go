@@ -0,0 +1,63 @@
+package container
+
+import "testing"
+
+func TestParseDevice(t *testing.T) {
+	device, err := ParseDevice("/dev/null:/dev/x:rw")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if device.Type != "c" || device.Major != 1 || device.Minor != 3 {
+		t.Errorf("got %s %d:%d, want c 1:3", device.Type, device.Major, device.Minor)
+	}
+	if device.PathInContainer != "/dev/x" || device.Permissions != "rw" {
+		t.Errorf("got %s %s, want /dev/x rw", device.PathInContainer, device.Permissions)
+	}
+
+	device, err = ParseDevice("/dev/zero")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if device.PathInContainer != "/dev/zero" || device.Permissions != "rwm" {
+		t.Errorf("got %s %s, want /dev/zero rwm", device.PathInContainer, device.Permissions)
+	}
+
+	invalid := []string{
+		"",
+		"/dev/null:/dev/x:rwz",
+		"/dev/null:x",
+		"/dev/null:/a:r:b",
+		"/etc/hostname",
+		"/dev/null:/../../../etc/x",
+		"/dev/null:/dev/../etc/x",
+		"/dev/null:/usr/x",
+		"/dev/null:/dev/",
+		"/dev/null:/dev//x",
+	}
+	for _, spec := range invalid {
+		if _, err := ParseDevice(spec); err == nil {
+			t.Errorf("ParseDevice(%q) expected error", spec)
+		}
+	}
+}
+
+func TestDeviceCgroupRules(t *testing.T) {
+	rules := DeviceCgroupRules([]*Device{{Type: "b", Major: 7, Minor: 0, Permissions: "r"}}, false)
+	for _, want := range []string{"c 1:3 rwm", "c 136:* rwm", "b 7:0 r"} {
+		if !contains(rules, want) {
+			t.Errorf("rules %v missing %q", rules, want)
+		}
+	}
+	if rules := DeviceCgroupRules(nil, true); len(rules) != 1 || rules[0] != "a *:* rwm" {
+		t.Errorf("privileged rules: got %v", rules)
+	}
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,229 @@
+package container
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/Sirupsen/logrus"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path"
+	"runtime"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/kkBill/mydocker/landlock"
+	"github.com/kkBill/mydocker/seccomp"
+)
+
+var (
+	ExecDirName string = "exec"
+)
+
+// 通过管道传给 exec 进程的配置
+type ProcessConfig struct {
+	Args         []string         `json:"args"`         //要执行的命令及参数
+	Env          []string         `json:"env"`          //环境变量
+	Cwd          string           `json:"cwd"`          //工作目录
+	User         string           `json:"user"`         //uid[:gid]
+	Tty          bool             `json:"tty"`          //是否分配了终端
+	Capabilities []string         `json:"capabilities"` //exec 进程保留的 capability
+	AmbientCaps  []string         `json:"ambientCaps"`  //--cap-add 指定的 capability，非 root 用户通过 ambient 集合获得
+	Seccomp      *seccomp.Profile `json:"seccomp"`      //与容器相同的 seccomp 配置，为空表示不过滤系统调用
+	Ulimits      []string         `json:"ulimits"`      //与容器相同的资源限制
+	Landlock     *landlock.Policy `json:"landlock"`     //与容器相同的 landlock 配置
+}
+
+// exec 会话的信息，保存在容器信息目录的 exec 子目录下
+// 前台会话结束时删除记录；后台会话(-d)的记录和输出日志在进程退出后仍然保留，直到容器被 rm 删除
+type ExecInfo struct {
+	Id          string `json:"id"`          //会话Id
+	Pid         string `json:"pid"`         //exec 进程在宿主机上的 PID
+	Command     string `json:"command"`     //执行的命令
+	User        string `json:"user"`        //执行命令的用户
+	Cwd         string `json:"cwd"`         //工作目录
+	Tty         bool   `json:"tty"`         //是否分配了终端
+	Detach      bool   `json:"detach"`      //是否后台运行
+	CreatedTime string `json:"createdTime"` //创建时间
+	Status      string `json:"status"`      //会话的状态
+	StartTime   string `json:"startTime"`   //exec 进程的启动时间(/proc/<pid>/stat 第 22 列)，用于识别被复用的 PID
+}
+
+// 在 nsenter 完成 setns 并 fork 之后，子进程会回到 go 运行时执行这个函数
+// 此时进程已经处于容器的各个 namespace 中，只需要按照配置切换目录、用户，再 exec 用户命令
+func RunContainerExecProcess() error {
+	config, err := readProcessConfig()
+	if err != nil {
+		return err
+	}
+	if len(config.Args) == 0 {
+		return fmt.Errorf("exec process get user command error, command array is nil")
+	}
+	runtime.LockOSThread()
+	if config.Tty {
+		if err := SetControllingTerminal(os.Stdin.Fd()); err != nil {
+			return fmt.Errorf("set controlling terminal error %v", err)
+		}
+	}
+	if config.Cwd != "" {
+		if err := syscall.Chdir(config.Cwd); err != nil {
+			return fmt.Errorf("chdir %s error %v", config.Cwd, err)
+		}
+	}
+	if err := setUlimits(config.Ulimits); err != nil {
+		return err
+	}
+	if err := dropBoundingCapabilities(config.Capabilities); err != nil {
+		return err
+	}
+	execUser, err := setupUser(config.User)
+	if err != nil {
+		return err
+	}
+	env := setHomeEnv(config.Env, execUser)
+	path, err := lookPathInEnv(config.Args[0], env)
+	if err != nil {
+		return err
+	}
+	if err := applyCapabilities(config.Capabilities, config.AmbientCaps, syscall.Getuid()); err != nil {
+		return err
+	}
+	if err := landlock.Restrict(config.Landlock); err != nil {
+		return err
+	}
+	if err := seccomp.InitSeccomp(config.Seccomp, config.Capabilities); err != nil {
+		return err
+	}
+	return syscall.Exec(path, config.Args, env)
+}
+
+// 用容器的环境变量查找命令的绝对路径
+func lookPathInEnv(file string, env []string) (string, error) {
+	os.Clearenv()
+	for _, e := range env {
+		if kv := strings.SplitN(e, "=", 2); len(kv) == 2 {
+			os.Setenv(kv[0], kv[1])
+		}
+	}
+	path, err := exec.LookPath(file)
+	if err != nil {
+		return "", fmt.Errorf("exec look path error: %v", err)
+	}
+	return path, nil
+}
+
+// 从 fd 3 的管道中读取父进程发送的配置
+func readProcessConfig() (*ProcessConfig, error) {
+	readPipe := os.NewFile(uintptr(3), "pipe")
+	defer readPipe.Close()
+	bytes, err := ioutil.ReadAll(readPipe)
+	if err != nil {
+		return nil, fmt.Errorf("read pipe error %v", err)
+	}
+	var config ProcessConfig
+	if err := json.Unmarshal(bytes, &config); err != nil {
+		return nil, fmt.Errorf("unmarshal process config error %v", err)
+	}
+	return &config, nil
+}
+
+// 保存 exec 会话信息，路径为 /var/run/mydocker/容器名/exec/会话Id.json
+func RecordExecInfo(containerName string, info *ExecInfo) error {
+	dirURL := path.Join(fmt.Sprintf(DefaultInfoLocation, containerName), ExecDirName)
+	if err := os.MkdirAll(dirURL, 0700); err != nil {
+		return err
+	}
+	bytes, err := json.Marshal(info)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path.Join(dirURL, info.Id+".json"), bytes, 0600)
+}
+
+// 删除 exec 会话信息
+func RemoveExecInfo(containerName, execID string) {
+	filePath := path.Join(fmt.Sprintf(DefaultInfoLocation, containerName), ExecDirName, execID+".json")
+	if err := os.Remove(filePath); err != nil && !os.IsNotExist(err) {
+		logrus.Errorf("Remove exec info %s error %v", filePath, err)
+	}
+}
+
+// 读取容器所有的 exec 会话，并根据进程是否存活更新会话状态
+func ListExecInfos(containerName string) ([]*ExecInfo, error) {
+	dirURL := path.Join(fmt.Sprintf(DefaultInfoLocation, containerName), ExecDirName)
+	files, err := ioutil.ReadDir(dirURL)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var infos []*ExecInfo
+	for _, file := range files {
+		if path.Ext(file.Name()) != ".json" {
+			continue
+		}
+		bytes, err := ioutil.ReadFile(path.Join(dirURL, file.Name()))
+		if err != nil {
+			logrus.Errorf("Read exec info %s error %v", file.Name(), err)
+			continue
+		}
+		var info ExecInfo
+		if err := json.Unmarshal(bytes, &info); err != nil {
+			logrus.Errorf("Unmarshal exec info %s error %v", file.Name(), err)
+			continue
+		}
+		if !execProcessAlive(&info) {
+			info.Status = Exit
+		}
+		infos = append(infos, &info)
+	}
+	return infos, nil
+}
+
+// 通过发送 0 号信号判断进程是否存在
+func processExists(pid string) bool {
+	pidInt, err := strconv.Atoi(pid)
+	if err != nil {
+		return false
+	}
+	return syscall.Kill(pidInt, 0) == nil
+}
+
+// 判断 exec 会话的进程是否还在运行
+// PID 可能在进程退出后被其他进程复用，所以还要比较进程的启动时间
+func execProcessAlive(info *ExecInfo) bool {
+	if !processExists(info.Pid) {
+		return false
+	}
+	if info.StartTime == "" {
+		// 没有记录启动时间的老会话只能相信 PID
+		return true
+	}
+	startTime, err := ProcessStartTime(info.Pid)
+	return err == nil && startTime == info.StartTime
+}
+
+// 读取进程的启动时间，单位为系统启动后的时钟滴答数
+func ProcessStartTime(pid string) (string, error) {
+	bytes, err := ioutil.ReadFile(fmt.Sprintf("/proc/%s/stat", pid))
+	if err != nil {
+		return "", err
+	}
+	return parseStartTime(string(bytes))
+}
+
+// 解析 /proc/<pid>/stat 中的 starttime 字段
+// 第 2 列的进程名可能包含空格和括号，所以从最后一个 ')' 之后开始分割，此时 starttime 是第 20 个字段
+func parseStartTime(stat string) (string, error) {
+	i := strings.LastIndex(stat, ")")
+	if i < 0 {
+		return "", fmt.Errorf("invalid proc stat %q", stat)
+	}
+	fields := strings.Fields(stat[i+1:])
+	if len(fields) < 20 {
+		return "", fmt.Errorf("invalid proc stat %q", stat)
+	}
+	return fields[19], nil
+}
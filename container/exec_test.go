@@ -0,0 +1,44 @@
+package container
+
+import (
+	"os"
+	"strconv"
+	"testing"
+)
+
+func TestParseStartTime(t *testing.T) {
+	tests := []struct {
+		stat    string
+		want    string
+		wantErr bool
+	}{
+		{"1 (init) S 0 1 1 0 -1 4194560 1 2 3 4 5 6 7 8 20 0 1 0 42 1000 100\n", "42", false},
+		{"7 (a) b (c) R 1 7 7 0 -1 0 0 0 0 0 0 0 0 0 20 0 1 0 12345 0 0", "12345", false},
+		{"9 (sh) S 1 9", "", true},
+		{"garbage", "", true},
+	}
+	for _, tt := range tests {
+		got, err := parseStartTime(tt.stat)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseStartTime(%q) error = %v, wantErr %v", tt.stat, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("parseStartTime(%q) = %q, want %q", tt.stat, got, tt.want)
+		}
+	}
+}
+
+func TestExecProcessAlive(t *testing.T) {
+	pid := strconv.Itoa(os.Getpid())
+	startTime, err := ProcessStartTime(pid)
+	if err != nil {
+		t.Fatalf("ProcessStartTime error %v", err)
+	}
+	if !execProcessAlive(&ExecInfo{Pid: pid, StartTime: startTime}) {
+		t.Errorf("current process should be alive")
+	}
+	if execProcessAlive(&ExecInfo{Pid: pid, StartTime: startTime + "0"}) {
+		t.Errorf("process with a different start time should be treated as exited")
+	}
+}
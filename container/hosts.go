@@ -0,0 +1,120 @@
+package container
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path"
+	"strings"
+)
+
+// 容器信息目录下生成的文件，会被 bind mount 到容器 rootfs 的同名文件上
+var (
+	HostsFile    string = "hosts"
+	ResolvFile   string = "resolv.conf"
+	HostnameFile string = "hostname"
+)
+
+// 宿主机没有可用的 DNS 服务器时使用的默认值
+var defaultDNS = []string{"8.8.8.8", "8.8.4.4"}
+
+// 检查 --add-host 的格式，必须是 name:ip
+func ParseExtraHost(extraHost string) (string, string, error) {
+	kv := strings.SplitN(extraHost, ":", 2)
+	if len(kv) != 2 || kv[0] == "" || net.ParseIP(kv[1]) == nil {
+		return "", "", fmt.Errorf("invalid add-host %q, must be name:ip", extraHost)
+	}
+	return kv[0], kv[1], nil
+}
+
+// 在容器信息目录下生成 hosts、resolv.conf 和 hostname 文件
+// 需要在配置完容器网络之后调用，hosts 文件中包含容器分配到的 IP
+func CreateEtcFiles(containerInfo *ContainerInfo) error {
+	dirURL := fmt.Sprintf(DefaultInfoLocation, containerInfo.Name)
+	files := map[string][]byte{
+		HostsFile:    buildHosts(containerInfo),
+		ResolvFile:   buildResolvConf(containerInfo),
+		HostnameFile: []byte(containerInfo.Hostname + "\n"),
+	}
+	for name, content := range files {
+		if err := ioutil.WriteFile(path.Join(dirURL, name), content, 0644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func buildHosts(containerInfo *ContainerInfo) []byte {
+	var buf bytes.Buffer
+	buf.WriteString("127.0.0.1\tlocalhost\n")
+	buf.WriteString("::1\tlocalhost ip6-localhost ip6-loopback\n")
+	if containerInfo.IPAddress != "" {
+		names := containerInfo.Hostname
+		if containerInfo.Domainname != "" {
+			names = containerInfo.Hostname + "." + containerInfo.Domainname + " " + names
+		}
+		fmt.Fprintf(&buf, "%s\t%s\n", containerInfo.IPAddress, names)
+	}
+	for _, extraHost := range containerInfo.ExtraHosts {
+		if name, ip, err := ParseExtraHost(extraHost); err == nil {
+			fmt.Fprintf(&buf, "%s\t%s\n", ip, name)
+		}
+	}
+	return buf.Bytes()
+}
+
+// 没有指定 --dns 时沿用宿主机的 DNS 服务器，
+// 但是要去掉 127.0.0.0/8 这样的本地地址，它们在容器的 network namespace 中是访问不到的
+func buildResolvConf(containerInfo *ContainerInfo) []byte {
+	nameservers := containerInfo.Dns
+	search := containerInfo.DnsSearch
+	hostNameservers, hostSearch := readHostResolvConf()
+	if len(nameservers) == 0 {
+		for _, ns := range hostNameservers {
+			if ip := net.ParseIP(ns); ip != nil && !ip.IsLoopback() {
+				nameservers = append(nameservers, ns)
+			}
+		}
+		if len(nameservers) == 0 {
+			nameservers = defaultDNS
+		}
+	}
+	if len(search) == 0 {
+		search = hostSearch
+	}
+
+	var buf bytes.Buffer
+	for _, ns := range nameservers {
+		fmt.Fprintf(&buf, "nameserver %s\n", ns)
+	}
+	if len(search) > 0 {
+		fmt.Fprintf(&buf, "search %s\n", strings.Join(search, " "))
+	}
+	return buf.Bytes()
+}
+
+func readHostResolvConf() ([]string, []string) {
+	file, err := os.Open("/etc/resolv.conf")
+	if err != nil {
+		return nil, nil
+	}
+	defer file.Close()
+	var nameservers, search []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 {
+			continue
+		}
+		switch fields[0] {
+		case "nameserver":
+			nameservers = append(nameservers, fields[1])
+		case "search", "domain":
+			search = fields[1:]
+		}
+	}
+	return nameservers, search
+}
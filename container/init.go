@@ -1,28 +1,138 @@
 package container
 
 import (
+	"encoding/json"
 	"fmt"
 	"github.com/Sirupsen/logrus"
+	"github.com/kkBill/mydocker/landlock"
+	"github.com/kkBill/mydocker/seccomp"
+	"github.com/vishvananda/netlink"
 	"io/ioutil"
 	"os"
 	"os/exec"
 	"path/filepath"
-	"strings"
+	"runtime"
 	"syscall"
 )
 
+// 通过管道传给容器 init 进程的配置
+type InitConfig struct {
+	Args         []string          `json:"args"`         //用户命令及参数
+	Hostname     string            `json:"hostname"`     //主机名
+	Domainname   string            `json:"domainname"`   //域名
+	EtcDir       string            `json:"etcDir"`       //hosts、resolv.conf、hostname 文件所在的目录，为空表示不挂载
+	Cwd          string            `json:"cwd"`          //工作目录，在 pivotRoot 之后切换
+	User         string            `json:"user"`         //name|uid[:group|gid]，从容器的 /etc/passwd、/etc/group 中解析
+	Overlay      string            `json:"overlay"`      //rootless 模式下挂载 rootfs 的 overlay 参数，为空表示宿主机已经挂载好了
+	Capabilities []string          `json:"capabilities"` //容器进程保留的 capability
+	AmbientCaps  []string          `json:"ambientCaps"`  //--cap-add 指定的 capability，非 root 用户通过 ambient 集合获得
+	Seccomp      *seccomp.Profile  `json:"seccomp"`      //seccomp 配置，为空表示不过滤系统调用
+	Landlock     *landlock.Policy  `json:"landlock"`     //landlock 配置，为空表示不限制文件系统访问
+	Privileged   bool              `json:"privileged"`   //特权容器不屏蔽内核路径，sysfs 可写
+	ReadOnly     bool              `json:"readOnly"`     //根目录只读
+	Devices      []*Device         `json:"devices"`      //--device 指定的设备
+	ShmSize      int64             `json:"shmSize"`      //共享内存 /dev/shm 的大小，单位为字节
+	Ulimits      []string          `json:"ulimits"`      //--ulimit 指定的资源限制
+	Sysctls      map[string]string `json:"sysctls"`      //--sysctl 指定的内核参数，写入容器的 /proc/sys
+	CloneFlags   uintptr           `json:"cloneFlags"`   //容器新建的 namespace，共享的 namespace 不做设置
+	Init         bool              `json:"init"`         //--init，由 mydocker 作为 1 号进程回收僵尸进程、转发信号
+}
+
 func RunContainerInitProcess() error {
-	commandArray := readUserCommand()
-	if commandArray == nil || len(commandArray) == 0 {
+	config := readInitConfig()
+	if config == nil || len(config.Args) == 0 {
 		return fmt.Errorf("run container get user command error, command array is nil")
 	}
+	commandArray := config.Args
+	// 设置 capability 和 exec 必须在同一个线程上
+	runtime.LockOSThread()
+	// time namespace 已经由 nsenter 创建好了，不要把环境变量带到容器进程中
+	os.Unsetenv(ENV_TIME_OFFSETS)
+
+	// 父进程发送配置之前已经把容器进程加入了 cgroup，这时再创建 cgroup namespace，容器内看到的 cgroup 路径为 /
+	// unshare 只对当前线程生效，之后在同一个线程上 exec
+	if config.CloneFlags&cloneNewCgroup != 0 {
+		if err := syscall.Unshare(cloneNewCgroup); err != nil {
+			logrus.Errorf("unshare cgroup namespace error: %v", err)
+			return err
+		}
+	}
+
+	if err := setUpHostname(config); err != nil {
+		logrus.Errorf("set hostname error: %v", err)
+		return err
+	}
+
+	// 容器有自己的 network namespace，至少保证 lo 可用，rootless 模式下这是唯一的网络
+	if config.CloneFlags&syscall.CLONE_NEWNET != 0 {
+		if err := setUpLoopback(); err != nil {
+			logrus.Warnf("set up loopback error: %v", err)
+		}
+	}
 
 	// linux only
 	// 不懂 2019-12-02
 	//defaultMountFlags := syscall.MS_NOEXEC | syscall.MS_NOSUID | syscall.MS_NODEV
 	//syscall.Mount("proc", "/proc", "proc", uintptr(defaultMountFlags), "")
 	// 设置挂载点 2019-12-03
-	setUpMount()
+	// 挂载失败时不能继续执行用户命令，否则容器进程会以宿主机的文件系统为根目录
+	if err := setUpMount(config); err != nil {
+		logrus.Errorf("set up mount error: %v", err)
+		return err
+	}
+	if err := setSysctls(config.Sysctls); err != nil {
+		logrus.Errorf("set sysctls error: %v", err)
+		return err
+	}
+	// sysctl 设置好之后再屏蔽内核路径，特权容器可以访问所有内核路径
+	if !config.Privileged {
+		if err := maskPaths(DefaultMaskedPaths); err != nil {
+			logrus.Errorf("mask paths error: %v", err)
+			return err
+		}
+		if err := readonlyPaths(DefaultReadonlyPaths); err != nil {
+			logrus.Errorf("readonly paths error: %v", err)
+			return err
+		}
+	}
+
+	// 工作目录不存在时先以 root 身份创建，切换用户之后再进入
+	if config.Cwd != "" {
+		if err := os.MkdirAll(config.Cwd, 0755); err != nil {
+			logrus.Errorf("mkdir workdir %s error: %v", config.Cwd, err)
+			return err
+		}
+	}
+	// 根目录最后变为只读，之前的步骤还需要在 rootfs 中创建目录
+	if config.ReadOnly {
+		if err := setUpReadonlyRootfs(); err != nil {
+			logrus.Errorf("set up read-only rootfs error: %v", err)
+			return err
+		}
+	}
+	if err := setUlimits(config.Ulimits); err != nil {
+		logrus.Errorf("set ulimits error: %v", err)
+		return err
+	}
+	if err := dropBoundingCapabilities(config.Capabilities); err != nil {
+		logrus.Errorf("drop capabilities error: %v", err)
+		return err
+	}
+	execUser, err := setupUser(config.User)
+	if err != nil {
+		logrus.Errorf("setup user %s error: %v", config.User, err)
+		return err
+	}
+	if config.Cwd != "" {
+		if err := syscall.Chdir(config.Cwd); err != nil {
+			logrus.Errorf("chdir %s error: %v", config.Cwd, err)
+			return err
+		}
+	}
+	if err := applyCapabilities(config.Capabilities, config.AmbientCaps, syscall.Getuid()); err != nil {
+		logrus.Errorf("apply capabilities error: %v", err)
+		return err
+	}
 
 	// exec.LookPath() 寻找命令的绝对路径
 	// 比如 exec.LookPath("ls") --> /usr/bin/ls
@@ -33,16 +143,32 @@ func RunContainerInitProcess() error {
 		return err
 	}
 
+	// landlock 在挂载完成、切换用户之后应用，规则中的路径是 pivotRoot 之后容器中的路径
+	if err := landlock.Restrict(config.Landlock); err != nil {
+		logrus.Errorf("landlock restrict error: %v", err)
+		return err
+	}
+
+	// seccomp 过滤器最后安装，之后只剩下 exec 用户命令
+	if err := seccomp.InitSeccomp(config.Seccomp, config.Capabilities); err != nil {
+		logrus.Errorf("init seccomp error: %v", err)
+		return err
+	}
+
+	env := setHomeEnv(os.Environ(), execUser)
+	if config.Init {
+		os.Exit(runInit(path, commandArray, env))
+	}
 	// 执行命令
-	if err := syscall.Exec(path, commandArray[0:], os.Environ()); err != nil {
-		logrus.Errorf(err.Error())
+	if err := syscall.Exec(path, commandArray[0:], env); err != nil {
+		logrus.Errorf("exec %s error: %v", path, err)
 	}
 	return nil
 }
 
 // 2019-12-02
 // 从父进程中通过匿名管道接收参数
-func readUserCommand() []string {
+func readInitConfig() *InitConfig {
 	// uintptr 是文件描述符类型
 	readPipe := os.NewFile(uintptr(3), "pipe")
 	bytes, err := ioutil.ReadAll(readPipe)
@@ -50,33 +176,111 @@ func readUserCommand() []string {
 		logrus.Errorf("init read pipe error %v", err)
 		return nil
 	}
-	msgStr := string(bytes)
-	logrus.Infof("readUserCommand(): %v", strings.Split(msgStr, " "))
-	return strings.Split(msgStr, " ")
+	var config InitConfig
+	if err := json.Unmarshal(bytes, &config); err != nil {
+		logrus.Errorf("init unmarshal config error %v", err)
+		return nil
+	}
+	logrus.Infof("readInitConfig(): %v", config.Args)
+	return &config
+}
+
+// 容器有自己的 UTS namespace，设置主机名和域名不会影响宿主机
+func setUpHostname(config *InitConfig) error {
+	if config.Hostname != "" {
+		if err := syscall.Sethostname([]byte(config.Hostname)); err != nil {
+			return err
+		}
+	}
+	if config.Domainname != "" {
+		if err := syscall.Setdomainname([]byte(config.Domainname)); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 // 初始化挂载点
-func setUpMount() {
+func setUpMount(config *InitConfig) error {
 	pwd, err := os.Getwd()
 	if err != nil {
-		logrus.Errorf("Get current location error %v", err)
-		return
+		return fmt.Errorf("get current location error %v", err)
 	}
 	logrus.Infof("setUpMount: Current location is %s", pwd)
 
-	pivotRoot(pwd)
+	// 先把挂载设置为私有，再挂载 /etc 下的文件，避免挂载传播到宿主机上
+	if err := syscall.Mount("", "/", "", syscall.MS_PRIVATE|syscall.MS_REC, ""); err != nil {
+		return fmt.Errorf("make parent mount private error %v", err)
+	}
+	// rootless 模式下在容器的 user namespace 中挂载 overlay，然后重新进入挂载点才能看到 rootfs 的内容
+	if config.Overlay != "" {
+		if err := syscall.Mount("overlay", pwd, "overlay", 0, config.Overlay); err != nil {
+			return fmt.Errorf("mount overlay on %s error %v", pwd, err)
+		}
+		if err := syscall.Chdir(pwd); err != nil {
+			return fmt.Errorf("chdir %s error %v", pwd, err)
+		}
+	}
+	if config.EtcDir != "" {
+		if err := mountEtcFiles(pwd, config.EtcDir); err != nil {
+			return fmt.Errorf("mount etc files error %v", err)
+		}
+	}
 
-	//mount proc
-	//syscall.Mount("", "/", "", syscall.MS_PRIVATE | syscall.MS_REC, "")
+	if err := setUpDev(pwd, config.Devices, config.ShmSize); err != nil {
+		return fmt.Errorf("set up /dev error %v", err)
+	}
 
-	// 目前的問題是挂載失敗
-	defaultMountFlags := syscall.MS_NOEXEC | syscall.MS_NOSUID | syscall.MS_NODEV
-	if err := syscall.Mount("proc", "/proc", "proc", uintptr(defaultMountFlags), ""); err != nil {
-		logrus.Infof("setUpMount: mount proc error: %v", err)
+	if err := mountProc(pwd); err != nil {
+		return err
 	}
-	if err := syscall.Mount("tmpfs", "/dev", "tmpfs", syscall.MS_NOSUID|syscall.MS_STRICTATIME, "mode=755"); err != nil {
-		logrus.Infof("setUpMount: mount tmpfs error: %v", err)
+
+	if err := pivotRoot(pwd); err != nil {
+		return fmt.Errorf("pivot root to %s error %v", pwd, err)
+	}
+	return mountSysfs(config.Privileged)
+}
+
+// 启用容器 network namespace 中的 lo 网卡
+func setUpLoopback() error {
+	lo, err := netlink.LinkByName("lo")
+	if err != nil {
+		return err
 	}
+	return netlink.LinkSetUp(lo)
+}
+
+// 把容器信息目录下生成的 hosts、resolv.conf、hostname 文件 bind mount 到 rootfs 的 /etc 下
+// 必须在 pivotRoot 之前完成，之后宿主机的文件系统就不可见了
+func mountEtcFiles(root, etcDir string) error {
+	// 镜像中的 /etc 可能是符号链接，必须在 rootfs 中解析，不能跟随到宿主机上
+	etc, err := securePath(root, "/etc")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(etc, 0755); err != nil {
+		return err
+	}
+	for _, name := range []string{HostsFile, ResolvFile, HostnameFile} {
+		target := filepath.Join(etc, name)
+		// bind mount 的目标必须存在，镜像中是符号链接时也替换成普通文件
+		// 删除的是符号链接本身，新文件用 O_EXCL|O_NOFOLLOW 创建，不会写到链接指向的文件
+		if fi, err := os.Lstat(target); err != nil || fi.Mode()&os.ModeSymlink != 0 {
+			if err := os.Remove(target); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+			file, err := os.OpenFile(target, os.O_CREATE|os.O_EXCL|os.O_WRONLY|syscall.O_NOFOLLOW, 0644)
+			if err != nil {
+				return err
+			}
+			file.Close()
+		}
+		source := filepath.Join(etcDir, name)
+		if err := syscall.Mount(source, target, "bind", syscall.MS_BIND, ""); err != nil {
+			return fmt.Errorf("bind mount %s to %s error %v", source, target, err)
+		}
+	}
+	return nil
 }
 
 func pivotRoot(root string) error {
@@ -0,0 +1,38 @@
+package container
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+
+	"github.com/kkBill/mydocker/landlock"
+)
+
+// 容器的 landlock 配置保存在容器信息目录下，exec 进程使用同样的配置
+var LandlockPolicyFile string = "landlock.json"
+
+// 保存容器的 landlock 配置，policy 为 nil 时不保存
+func SaveLandlockPolicy(containerName string, policy *landlock.Policy) error {
+	if policy == nil {
+		return nil
+	}
+	bytes, err := json.Marshal(policy)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path.Join(fmt.Sprintf(DefaultInfoLocation, containerName), LandlockPolicyFile), bytes, 0600)
+}
+
+// 读取容器的 landlock 配置，文件不存在表示容器没有使用 landlock，返回 nil
+func LoadLandlockPolicy(containerName string) (*landlock.Policy, error) {
+	bytes, err := ioutil.ReadFile(path.Join(fmt.Sprintf(DefaultInfoLocation, containerName), LandlockPolicyFile))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return landlock.ParsePolicy(bytes)
+}
@@ -0,0 +1,78 @@
+package container
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/Sirupsen/logrus"
+)
+
+// --init 时由 mydocker 作为容器的 1 号进程，用户命令作为它的子进程运行：
+// 1.在新的进程组中启动用户命令，有终端时把这个进程组设为前台进程组
+// 2.把收到的信号转发给用户命令所在的进程组
+// 3.回收所有退出的子进程，包括托管给 1 号进程的孤儿进程，避免僵尸进程堆积
+// 4.用户命令退出后以它的退出码退出，被信号杀死时为 128+信号
+// 用户、capability、seccomp 等设置都已经在当前线程上完成，fork 出的子进程会继承
+func runInit(path string, args, env []string) int {
+	sigs := make(chan os.Signal, 32)
+	signal.Notify(sigs)
+
+	attr := &syscall.SysProcAttr{Setpgid: true}
+	if IsTerminal(0) {
+		attr.Foreground = true
+		attr.Ctty = 0
+	}
+	pid, err := syscall.ForkExec(path, args, &syscall.ProcAttr{
+		Env:   env,
+		Files: []uintptr{0, 1, 2},
+		Sys:   attr,
+	})
+	if err != nil {
+		logrus.Errorf("start %s error: %v", path, err)
+		return 127
+	}
+
+	for sig := range sigs {
+		switch sig {
+		case syscall.SIGCHLD:
+			if status, exited := reapChildren(pid); exited {
+				return ExitCode(status)
+			}
+		case syscall.SIGURG:
+			// go 运行时用 SIGURG 抢占 goroutine，不是发给容器的信号
+		default:
+			if err := syscall.Kill(-pid, sig.(syscall.Signal)); err != nil && err != syscall.ESRCH {
+				logrus.Warnf("forward signal %v error: %v", sig, err)
+			}
+		}
+	}
+	return 0
+}
+
+// 回收所有已经退出的子进程，返回用户命令是否已经退出及其状态
+func reapChildren(pid int) (syscall.WaitStatus, bool) {
+	var mainStatus syscall.WaitStatus
+	exited := false
+	for {
+		var status syscall.WaitStatus
+		wpid, err := syscall.Wait4(-1, &status, syscall.WNOHANG, nil)
+		if err == syscall.EINTR {
+			continue
+		}
+		if err != nil || wpid <= 0 {
+			return mainStatus, exited
+		}
+		if wpid == pid {
+			mainStatus, exited = status, true
+		}
+	}
+}
+
+// 与 shell 的约定相同，被信号杀死的进程退出码为 128+信号
+func ExitCode(status syscall.WaitStatus) int {
+	if status.Signaled() {
+		return 128 + int(status.Signal())
+	}
+	return status.ExitStatus()
+}
@@ -0,0 +1,20 @@
+package container
+
+import (
+	"syscall"
+	"testing"
+)
+
+func TestExitCode(t *testing.T) {
+	// wait 状态的低 7 位为杀死进程的信号，正常退出时退出码在 8~15 位
+	for status, want := range map[syscall.WaitStatus]int{
+		0:                                   0,
+		3 << 8:                              3,
+		syscall.WaitStatus(syscall.SIGTERM): 143,
+		syscall.WaitStatus(syscall.SIGKILL): 137,
+	} {
+		if got := ExitCode(status); got != want {
+			t.Errorf("status %#x: got %d, want %d", uint32(status), got, want)
+		}
+	}
+}
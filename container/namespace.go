@@ -0,0 +1,179 @@
+package container
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+	"syscall"
+
+	"github.com/vishvananda/netns"
+)
+
+// --pid、--ipc、--uts、--net 的取值，为空表示创建新的 namespace
+const (
+	NamespaceHost            = "host"
+	NamespaceContainerPrefix = "container:"
+	NamespacePodPrefix       = "pod:" //由 --pod 设置，加入 pod 的 infra 进程的 namespace
+)
+
+// syscall 包中没有 cgroup namespace 的 clone 标志
+const cloneNewCgroup = 0x02000000
+
+// 可以共享的 namespace 及其在 /proc/<pid>/ns 下的文件名，按照 setns 的顺序排列
+var sharableNamespaces = []struct {
+	name string
+	flag uintptr
+}{
+	{"ipc", syscall.CLONE_NEWIPC},
+	{"uts", syscall.CLONE_NEWUTS},
+	{"net", syscall.CLONE_NEWNET},
+	{"pid", syscall.CLONE_NEWPID},
+}
+
+// 容器各个 namespace 的模式：空表示新建，host 表示使用宿主机的，container:<name> 表示加入其他容器的
+type NamespaceConfig struct {
+	Pid    string `json:"pid,omitempty"`
+	Ipc    string `json:"ipc,omitempty"`
+	Uts    string `json:"uts,omitempty"`
+	Net    string `json:"net,omitempty"`
+	Cgroup string `json:"cgroup,omitempty"` //只能为空或 host
+}
+
+// 检查 namespace 的模式，返回 container:<name> 中的容器名
+func ParseNamespaceMode(name, mode string) (string, error) {
+	switch {
+	case mode == "" || mode == NamespaceHost:
+		return "", nil
+	case strings.HasPrefix(mode, NamespaceContainerPrefix):
+		container := strings.TrimPrefix(mode, NamespaceContainerPrefix)
+		if container == "" {
+			return "", fmt.Errorf("invalid %s namespace mode %q, missing container name", name, mode)
+		}
+		return container, nil
+	}
+	return "", fmt.Errorf("invalid %s namespace mode %q, must be host or container:<name>", name, mode)
+}
+
+// 检查 --cgroupns 的模式，private 与不指定相同，都创建新的 cgroup namespace
+func ParseCgroupnsMode(mode string) (string, error) {
+	switch mode {
+	case "", "private":
+		return "", nil
+	case NamespaceHost:
+		return NamespaceHost, nil
+	}
+	return "", fmt.Errorf("invalid cgroupns mode %q, must be host or private", mode)
+}
+
+// 是否是 --net 的 namespace 模式，否则 --net 指定的是要连接的网络
+func IsNamespaceMode(mode string) bool {
+	return mode == NamespaceHost || strings.HasPrefix(mode, NamespaceContainerPrefix)
+}
+
+func (c *NamespaceConfig) mode(name string) string {
+	if c == nil {
+		return ""
+	}
+	switch name {
+	case "pid":
+		return c.Pid
+	case "ipc":
+		return c.Ipc
+	case "uts":
+		return c.Uts
+	case "net":
+		return c.Net
+	case "cgroup":
+		return c.Cgroup
+	}
+	return ""
+}
+
+// 创建容器进程时使用的 clone 标志，共享的 namespace 不再新建
+func (c *NamespaceConfig) CloneFlags() uintptr {
+	flags := uintptr(DefaultCloneFlags)
+	for _, ns := range sharableNamespaces {
+		if c.mode(ns.name) != "" {
+			flags &^= ns.flag
+		}
+	}
+	if c.mode("cgroup") == NamespaceHost {
+		flags &^= cloneNewCgroup
+	}
+	return flags
+}
+
+// 要加入的其他容器或 pod 的 namespace，返回 namespace 名到 container:<name> 或 pod:<name> 的映射
+func (c *NamespaceConfig) Joined() map[string]string {
+	joined := map[string]string{}
+	for _, ns := range sharableNamespaces {
+		mode := c.mode(ns.name)
+		if strings.HasPrefix(mode, NamespaceContainerPrefix) || strings.HasPrefix(mode, NamespacePodPrefix) {
+			joined[ns.name] = mode
+		}
+	}
+	return joined
+}
+
+// 容器有自己的 user namespace 时，不能共享宿主机或其他容器、pod 的 pid、network、ipc namespace：
+// 这些 namespace 属于宿主机的 user namespace，容器内的 root 无法在其中挂载 proc、sysfs 或修改内核参数
+// go 运行时是多线程的，无法 setns 进入其他容器的 user namespace，所以要求使用 --userns=host
+func (c *NamespaceConfig) CheckUserns(userns *UsernsConfig) error {
+	if userns == nil || userns.Host {
+		return nil
+	}
+	for _, name := range []string{"pid", "net", "ipc"} {
+		mode := c.mode(name)
+		if strings.HasPrefix(mode, NamespacePodPrefix) {
+			return fmt.Errorf("--pod requires --userns=host, the pod namespaces are not owned by the container user namespace")
+		}
+		if mode != "" {
+			return fmt.Errorf("--%s=%s requires --userns=host, the container user namespace can not own a shared %s namespace", name, mode, name)
+		}
+	}
+	return nil
+}
+
+// 在锁定的线程上 setns 进入 paths 中的 namespace 后再启动容器进程，子进程会继承这个线程的 namespace
+// paths 是 namespace 名到 /proc/<pid>/ns/<name> 的映射，进入 pid namespace 只对之后创建的子进程生效，正好满足需要
+// 线程的 namespace 已经被修改，不能再交给 go 运行时调度其他 goroutine，所以不解锁线程，goroutine 退出时线程随之销毁
+func StartInNamespaces(cmd *exec.Cmd, paths map[string]string) error {
+	if len(paths) == 0 {
+		return cmd.Start()
+	}
+	errCh := make(chan error, 1)
+	go func() {
+		runtime.LockOSThread()
+		errCh <- setnsAndStart(cmd, paths)
+	}()
+	return <-errCh
+}
+
+func setnsAndStart(cmd *exec.Cmd, paths map[string]string) error {
+	// 先打开所有 namespace 文件，再依次 setns，避免进入 pid namespace 之后目标进程退出
+	var files []*os.File
+	defer func() {
+		for _, f := range files {
+			f.Close()
+		}
+	}()
+	for _, ns := range sharableNamespaces {
+		p, ok := paths[ns.name]
+		if !ok {
+			continue
+		}
+		f, err := os.Open(p)
+		if err != nil {
+			return fmt.Errorf("open %s namespace %s error %v", ns.name, p, err)
+		}
+		files = append(files, f)
+	}
+	for _, f := range files {
+		if err := netns.Setns(netns.NsHandle(f.Fd()), 0); err != nil {
+			return fmt.Errorf("setns %s error %v", f.Name(), err)
+		}
+	}
+	return cmd.Start()
+}
@@ -0,0 +1,77 @@
+package container
+
+import (
+	"syscall"
+	"testing"
+)
+
+func TestNamespaceConfig(t *testing.T) {
+	var nilConfig *NamespaceConfig
+	if nilConfig.CloneFlags() != DefaultCloneFlags {
+		t.Errorf("nil config clone flags: got %#x", nilConfig.CloneFlags())
+	}
+
+	c := &NamespaceConfig{Pid: NamespaceHost, Net: "container:web", Ipc: "pod:app"}
+	want := uintptr(syscall.CLONE_NEWUTS | syscall.CLONE_NEWNS | cloneNewCgroup)
+	if got := c.CloneFlags(); got != want {
+		t.Errorf("clone flags: got %#x, want %#x", got, want)
+	}
+	joined := c.Joined()
+	if len(joined) != 2 || joined["net"] != "container:web" || joined["ipc"] != "pod:app" {
+		t.Errorf("joined: got %v", joined)
+	}
+
+	c = &NamespaceConfig{Cgroup: NamespaceHost}
+	if got := c.CloneFlags(); got&cloneNewCgroup != 0 {
+		t.Errorf("cgroupns host clone flags: got %#x", got)
+	}
+}
+
+func TestParseCgroupnsMode(t *testing.T) {
+	for mode, want := range map[string]string{"": "", "private": "", "host": NamespaceHost} {
+		if got, err := ParseCgroupnsMode(mode); err != nil || got != want {
+			t.Errorf("%q: got %q, %v", mode, got, err)
+		}
+	}
+	if _, err := ParseCgroupnsMode("container:web"); err == nil {
+		t.Errorf("container:web expected error")
+	}
+}
+
+func TestParseNamespaceMode(t *testing.T) {
+	if name, err := ParseNamespaceMode("net", "container:web"); err != nil || name != "web" {
+		t.Errorf("container:web: got %q, %v", name, err)
+	}
+	for _, mode := range []string{"", NamespaceHost} {
+		if _, err := ParseNamespaceMode("pid", mode); err != nil {
+			t.Errorf("%q: %v", mode, err)
+		}
+	}
+	for _, mode := range []string{"container:", "private", "bridge"} {
+		if _, err := ParseNamespaceMode("ipc", mode); err == nil {
+			t.Errorf("%q expected error", mode)
+		}
+	}
+}
+
+func TestCheckUserns(t *testing.T) {
+	newUserns := &UsernsConfig{}
+	tests := []struct {
+		namespaces *NamespaceConfig
+		userns     *UsernsConfig
+		wantErr    bool
+	}{
+		{&NamespaceConfig{}, newUserns, false},
+		{&NamespaceConfig{Uts: NamespaceHost, Cgroup: NamespaceHost}, newUserns, false},
+		{&NamespaceConfig{Pid: NamespaceHost}, newUserns, true},
+		{&NamespaceConfig{Pid: "container:web"}, newUserns, true},
+		{&NamespaceConfig{Net: NamespaceHost}, newUserns, true},
+		{&NamespaceConfig{Ipc: "pod:app"}, newUserns, true},
+		{&NamespaceConfig{Pid: NamespaceHost, Net: "container:web"}, &UsernsConfig{Host: true}, false},
+	}
+	for _, tt := range tests {
+		if err := tt.namespaces.CheckUserns(tt.userns); (err != nil) != tt.wantErr {
+			t.Errorf("CheckUserns(%+v, %+v) error = %v, wantErr %v", tt.namespaces, tt.userns, err, tt.wantErr)
+		}
+	}
+}
@@ -0,0 +1,126 @@
+package container
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path"
+	"syscall"
+)
+
+// pod 的信息保存在容器信息目录的 pod 子目录下，例如 /var/run/mydocker/pod/pod名/config.json
+var PodDirName string = "pod"
+
+// pod 中容器的 cgroup 都创建在 pod 的 cgroup 下面
+const PodCgroupPrefix = "mydocker-pod-"
+
+// 一组共享 network、ipc、uts namespace 和父 cgroup 的容器
+// infra 进程只负责持有这些 namespace，pod 中的容器都加入 infra 进程的 namespace
+type PodInfo struct {
+	Id          string   `json:"id"`          //pod Id
+	Name        string   `json:"name"`        //pod 名
+	InfraPid    string   `json:"infraPid"`    //infra 进程在宿主机上的 PID
+	Hostname    string   `json:"hostname"`    //pod 中所有容器共用的主机名
+	Network     string   `json:"network"`     //pod 连接的网络
+	IPAddress   string   `json:"ipAddress"`   //pod 在网络中分配到的 IP
+	PortMapping []string `json:"portmapping"` //端口映射
+	CgroupPath  string   `json:"cgroupPath"`  //pod 的父 cgroup
+	CreatedTime string   `json:"createdTime"` //创建时间
+	Status      string   `json:"status"`      //infra 进程的状态
+}
+
+// pod 的 cgroup 路径，pod 中容器的 cgroup 为 pod 的 cgroup 路径/容器名
+func PodCgroupPath(podName string) string {
+	return PodCgroupPrefix + podName
+}
+
+func podDir(podName string) string {
+	return path.Join(fmt.Sprintf(DefaultInfoLocation, PodDirName), podName)
+}
+
+// 保存 pod 信息
+func RecordPodInfo(info *PodInfo) error {
+	dirURL := podDir(info.Name)
+	if err := os.MkdirAll(dirURL, 0700); err != nil {
+		return err
+	}
+	bytes, err := json.Marshal(info)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path.Join(dirURL, ConfigName), bytes, 0600)
+}
+
+// 读取 pod 信息，并根据 infra 进程是否存活更新状态
+func GetPodInfo(podName string) (*PodInfo, error) {
+	bytes, err := ioutil.ReadFile(path.Join(podDir(podName), ConfigName))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("no such pod: %s", podName)
+		}
+		return nil, err
+	}
+	var info PodInfo
+	if err := json.Unmarshal(bytes, &info); err != nil {
+		return nil, err
+	}
+	if !processExists(info.InfraPid) {
+		info.Status = Exit
+	}
+	return &info, nil
+}
+
+// 读取所有 pod 的信息
+func ListPodInfos() ([]*PodInfo, error) {
+	files, err := ioutil.ReadDir(fmt.Sprintf(DefaultInfoLocation, PodDirName))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var infos []*PodInfo
+	for _, file := range files {
+		info, err := GetPodInfo(file.Name())
+		if err != nil {
+			return nil, err
+		}
+		infos = append(infos, info)
+	}
+	return infos, nil
+}
+
+// 删除 pod 信息
+func RemovePodInfo(podName string) error {
+	return os.RemoveAll(podDir(podName))
+}
+
+// 创建 pod 的 infra 进程，它运行在新的 network、ipc、uts namespace 中，并脱离当前终端的会话
+func NewPauseProcess(hostname string) *exec.Cmd {
+	cmd := exec.Command("/proc/self/exe", "pause", hostname)
+	cmd.SysProcAttr = &syscall.SysProcAttr{
+		Cloneflags: syscall.CLONE_NEWNET | syscall.CLONE_NEWIPC | syscall.CLONE_NEWUTS,
+		Setsid:     true,
+	}
+	return cmd
+}
+
+// infra 进程：设置 pod 的主机名，然后一直等待，直到收到 SIGTERM 或 SIGINT
+func RunPauseProcess(hostname string) error {
+	if hostname != "" {
+		if err := syscall.Sethostname([]byte(hostname)); err != nil {
+			return fmt.Errorf("set hostname error %v", err)
+		}
+	}
+	if err := setUpLoopback(); err != nil {
+		return fmt.Errorf("set up loopback error %v", err)
+	}
+	signal.Ignore(syscall.SIGHUP)
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGTERM, syscall.SIGINT)
+	<-sigs
+	return nil
+}
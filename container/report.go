@@ -0,0 +1,17 @@
+package container
+
+import "time"
+
+// 容器的退出原因，正常退出时为空
+const ExitReasonTimeout = "timeout" //超过 --timeout 指定的运行时间被停止
+
+// --report 时容器退出后生成的汇总
+type Report struct {
+	WallTime       time.Duration `json:"wallTime"`             //从启动到退出经过的时间
+	CpuUser        time.Duration `json:"cpuUser"`              //用户态 cpu 时间
+	CpuSystem      time.Duration `json:"cpuSystem"`            //内核态 cpu 时间
+	MemoryMaxUsage uint64        `json:"memoryMaxUsage"`       //内存使用的峰值，单位为字节
+	OomKills       uint64        `json:"oomKills"`             //被 OOM killer 杀死的进程数
+	ExitCode       int           `json:"exitCode"`             //退出码
+	ExitReason     string        `json:"exitReason,omitempty"` //退出原因
+}
@@ -0,0 +1,104 @@
+package container
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// --ulimit 中可以使用的资源名及其在内核中的编号，见 linux/resource.h
+var rlimitNames = map[string]int{
+	"cpu":        0,
+	"fsize":      1,
+	"data":       2,
+	"stack":      3,
+	"core":       4,
+	"rss":        5,
+	"nproc":      6,
+	"nofile":     7,
+	"memlock":    8,
+	"as":         9,
+	"locks":      10,
+	"sigpending": 11,
+	"msgqueue":   12,
+	"nice":       13,
+	"rtprio":     14,
+	"rttime":     15,
+}
+
+// 一条 --ulimit 资源限制
+type Ulimit struct {
+	Name     string
+	Resource int
+	Soft     uint64
+	Hard     uint64
+}
+
+// 表示不限制的资源限制值
+const rlimInfinity = ^uint64(0)
+
+// oom_score_adj 的取值范围
+const (
+	OomScoreAdjMin = -1000
+	OomScoreAdjMax = 1000
+)
+
+// 解析 --ulimit name=soft[:hard]，省略 hard 时与 soft 相同，-1 表示不限制
+func ParseUlimit(spec string) (*Ulimit, error) {
+	kv := strings.SplitN(spec, "=", 2)
+	if len(kv) != 2 {
+		return nil, fmt.Errorf("invalid ulimit %q, must be name=soft[:hard]", spec)
+	}
+	resource, ok := rlimitNames[kv[0]]
+	if !ok {
+		return nil, fmt.Errorf("invalid ulimit %q, unknown resource %s", spec, kv[0])
+	}
+	limits := strings.SplitN(kv[1], ":", 2)
+	soft, err := parseRlimitValue(limits[0])
+	if err != nil {
+		return nil, fmt.Errorf("invalid ulimit %q, %v", spec, err)
+	}
+	hard := soft
+	if len(limits) == 2 {
+		if hard, err = parseRlimitValue(limits[1]); err != nil {
+			return nil, fmt.Errorf("invalid ulimit %q, %v", spec, err)
+		}
+	}
+	if soft > hard {
+		return nil, fmt.Errorf("invalid ulimit %q, soft limit must not be greater than hard limit", spec)
+	}
+	return &Ulimit{Name: kv[0], Resource: resource, Soft: soft, Hard: hard}, nil
+}
+
+func parseRlimitValue(s string) (uint64, error) {
+	if s == "-1" || s == "unlimited" {
+		return rlimInfinity, nil
+	}
+	return strconv.ParseUint(s, 10, 64)
+}
+
+// 在 exec 用户命令之前设置资源限制，提高硬限制需要 CAP_SYS_RESOURCE，所以要在去掉 capability 之前调用
+func setUlimits(specs []string) error {
+	for _, spec := range specs {
+		ulimit, err := ParseUlimit(spec)
+		if err != nil {
+			return err
+		}
+		rlimit := &syscall.Rlimit{Cur: ulimit.Soft, Max: ulimit.Hard}
+		if err := syscall.Setrlimit(ulimit.Resource, rlimit); err != nil {
+			return fmt.Errorf("setrlimit %s error %v", ulimit.Name, err)
+		}
+	}
+	return nil
+}
+
+// 由宿主机上的父进程设置容器进程的 oom_score_adj，容器内的 root 在 user namespace 中不能调低这个值
+func SetOomScoreAdj(pid int, score int) error {
+	file := fmt.Sprintf("/proc/%d/oom_score_adj", pid)
+	if err := ioutil.WriteFile(file, []byte(strconv.Itoa(score)), 0644); err != nil {
+		return fmt.Errorf("set oom_score_adj of %d error %v", pid, err)
+	}
+	return nil
+}
@@ -0,0 +1,32 @@
+package container
+
+import "testing"
+
+func TestParseUlimit(t *testing.T) {
+	tests := []struct {
+		spec       string
+		resource   int
+		soft, hard uint64
+	}{
+		{"nofile=1024:2048", 7, 1024, 2048},
+		{"nproc=512", 6, 512, 512},
+		{"core=0:-1", 4, 0, rlimInfinity},
+		{"memlock=unlimited", 8, rlimInfinity, rlimInfinity},
+	}
+	for _, test := range tests {
+		ulimit, err := ParseUlimit(test.spec)
+		if err != nil {
+			t.Errorf("ParseUlimit(%q) error %v", test.spec, err)
+			continue
+		}
+		if ulimit.Resource != test.resource || ulimit.Soft != test.soft || ulimit.Hard != test.hard {
+			t.Errorf("ParseUlimit(%q) = %+v", test.spec, ulimit)
+		}
+	}
+
+	for _, spec := range []string{"nofile", "foo=1", "nofile=a", "nofile=1:b", "nofile=2048:1024", "nofile=-1:1024"} {
+		if _, err := ParseUlimit(spec); err == nil {
+			t.Errorf("ParseUlimit(%q) expected error", spec)
+		}
+	}
+}
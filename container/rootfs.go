@@ -0,0 +1,275 @@
+package container
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"github.com/Sirupsen/logrus"
+)
+
+// 默认屏蔽的内核路径，文件用 /dev/null 覆盖，目录用只读的空 tmpfs 覆盖，与 docker 的默认值一致
+var DefaultMaskedPaths = []string{
+	"/proc/asound",
+	"/proc/acpi",
+	"/proc/kcore",
+	"/proc/keys",
+	"/proc/latency_stats",
+	"/proc/timer_list",
+	"/proc/timer_stats",
+	"/proc/sched_debug",
+	"/proc/scsi",
+	"/sys/firmware",
+	"/sys/devices/virtual/powercap",
+}
+
+// 默认只读的内核路径
+var DefaultReadonlyPaths = []string{
+	"/proc/bus",
+	"/proc/fs",
+	"/proc/irq",
+	"/proc/sys",
+	"/proc/sysrq-trigger",
+}
+
+// --read-only 时根目录只读，这些目录挂载为 tmpfs 供程序写临时文件
+var ReadonlyTmpfsPaths = []string{"/tmp", "/run"}
+
+// /dev 下指向 /proc 和 devpts 的符号链接
+var devSymlinks = [][2]string{
+	{"/proc/self/fd", "fd"},
+	{"/proc/self/fd/0", "stdin"},
+	{"/proc/self/fd/1", "stdout"},
+	{"/proc/self/fd/2", "stderr"},
+	{"pts/ptmx", "ptmx"},
+}
+
+// 解析路径时最多跟随的符号链接个数，与内核的限制一致
+const maxSymlinks = 40
+
+// 在 rootfs 中解析路径：pivotRoot 之前路径中的每一级符号链接都按照容器的根目录解析，
+// 指向绝对路径或者 .. 的符号链接不会解析到宿主机上。返回的路径中不含符号链接，不存在的部分原样拼接
+func securePath(root, unsafePath string) (string, error) {
+	resolved := "/"
+	remaining := unsafePath
+	links := 0
+	for remaining != "" {
+		part := remaining
+		remaining = ""
+		if i := strings.IndexByte(part, '/'); i >= 0 {
+			part, remaining = part[:i], part[i+1:]
+		}
+		if part == "" || part == "." {
+			continue
+		}
+		// 在 / 下 .. 仍然是 /，不会超出 rootfs
+		next := filepath.Join(resolved, part)
+		fi, err := os.Lstat(filepath.Join(root, next))
+		if err != nil {
+			if !os.IsNotExist(err) {
+				return "", err
+			}
+			resolved = next
+			continue
+		}
+		if fi.Mode()&os.ModeSymlink == 0 {
+			resolved = next
+			continue
+		}
+		links++
+		if links > maxSymlinks {
+			return "", fmt.Errorf("resolve %s in %s: too many levels of symbolic links", unsafePath, root)
+		}
+		link, err := os.Readlink(filepath.Join(root, next))
+		if err != nil {
+			return "", err
+		}
+		if filepath.IsAbs(link) {
+			resolved = "/"
+		}
+		remaining = link + "/" + remaining
+	}
+	return filepath.Join(root, resolved), nil
+}
+
+// 在 pivotRoot 之前为容器挂载 /dev，此时宿主机上的设备文件还能访问到：
+// 1.挂载 tmpfs，创建默认的设备文件和 --device 指定的设备文件，user namespace 中不能 mknod 时从宿主机 bind mount
+// 2.挂载新实例的 devpts，与宿主机的伪终端隔离
+// 3.挂载 shmSize 大小的 /dev/shm
+// 4.创建 /dev/fd、stdin、stdout、stderr、ptmx 符号链接
+func setUpDev(root string, devices []*Device, shmSize int64) error {
+	// 镜像中的 /dev 可能是符号链接，必须在 rootfs 中解析，不能跟随到宿主机上
+	dev, err := securePath(root, "/dev")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dev, 0755); err != nil {
+		return err
+	}
+	if err := syscall.Mount("tmpfs", dev, "tmpfs", syscall.MS_NOSUID|syscall.MS_STRICTATIME, "mode=755"); err != nil {
+		return fmt.Errorf("mount tmpfs on %s error %v", dev, err)
+	}
+	for _, device := range append(append([]*Device(nil), DefaultDevices...), devices...) {
+		if err := createDevice(dev, device); err != nil {
+			return err
+		}
+	}
+
+	pts := filepath.Join(dev, "pts")
+	if err := os.MkdirAll(pts, 0755); err != nil {
+		return err
+	}
+	// 只映射了 root 的 user namespace 中没有 tty 组（gid 5），去掉 gid 选项重试
+	ptsFlags := uintptr(syscall.MS_NOSUID | syscall.MS_NOEXEC)
+	if err := syscall.Mount("devpts", pts, "devpts", ptsFlags, "newinstance,ptmxmode=0666,mode=0620,gid=5"); err != nil {
+		if err := syscall.Mount("devpts", pts, "devpts", ptsFlags, "newinstance,ptmxmode=0666,mode=0620"); err != nil {
+			return fmt.Errorf("mount devpts error %v", err)
+		}
+	}
+
+	shm := filepath.Join(dev, "shm")
+	if err := os.MkdirAll(shm, 0755); err != nil {
+		return err
+	}
+	if shmSize <= 0 {
+		shmSize = DefaultShmSize
+	}
+	shmFlags := uintptr(syscall.MS_NOSUID | syscall.MS_NODEV | syscall.MS_NOEXEC)
+	if err := syscall.Mount("shm", shm, "tmpfs", shmFlags, fmt.Sprintf("mode=1777,size=%d", shmSize)); err != nil {
+		return fmt.Errorf("mount /dev/shm error %v", err)
+	}
+
+	for _, link := range devSymlinks {
+		if err := os.Symlink(link[0], filepath.Join(dev, link[1])); err != nil && !os.IsExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// 把宿主机上的设备文件 bind mount 到容器中
+func bindDevice(source, target string) error {
+	file, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	file.Close()
+	if err := syscall.Mount(source, target, "bind", syscall.MS_BIND, ""); err != nil {
+		return fmt.Errorf("bind mount %s to %s error %v", source, target, err)
+	}
+	return nil
+}
+
+// 在 rootfs 的 /proc 上挂载 proc，必须在 pivotRoot 之前调用：
+// user namespace 中挂载 proc 时，内核要求当前 mount namespace 中已经有一个完整可见的 proc，pivotRoot 卸载旧的根目录之后就没有了
+// 容器的 user namespace 不拥有 pid namespace 时（例如 --pid=host）同样无法挂载，这里直接返回错误，不能让容器看到错误的 /proc
+func mountProc(root string) error {
+	// 镜像中的 /proc 可能是符号链接，必须在 rootfs 中解析
+	proc, err := securePath(root, "/proc")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(proc, 0555); err != nil {
+		return err
+	}
+	if err := syscall.Mount("proc", proc, "proc", syscall.MS_NOEXEC|syscall.MS_NOSUID|syscall.MS_NODEV, ""); err != nil {
+		return fmt.Errorf("mount proc on %s error %v", proc, err)
+	}
+	return nil
+}
+
+// 挂载 sysfs，非特权容器只读挂载
+// 与宿主机共享 network namespace 等情况下内核不允许挂载 sysfs，这时在 /sys 上挂载只读的空 tmpfs，
+// 保证容器中的 /sys 不会暴露任何内容
+func mountSysfs(privileged bool) error {
+	flags := uintptr(syscall.MS_NOEXEC | syscall.MS_NOSUID | syscall.MS_NODEV)
+	if !privileged {
+		flags |= syscall.MS_RDONLY
+	}
+	if err := os.MkdirAll("/sys", 0755); err != nil {
+		return err
+	}
+	err := syscall.Mount("sysfs", "/sys", "sysfs", flags, "")
+	if err == nil {
+		return nil
+	}
+	if err != syscall.EPERM {
+		return fmt.Errorf("mount sysfs error %v", err)
+	}
+	logrus.Warnf("mount sysfs error: %v, mounting an empty read-only /sys instead", err)
+	if err := syscall.Mount("tmpfs", "/sys", "tmpfs", flags|syscall.MS_RDONLY, "mode=755"); err != nil {
+		return fmt.Errorf("mount empty /sys error %v", err)
+	}
+	return nil
+}
+
+// 屏蔽内核路径，不存在的路径跳过
+func maskPaths(paths []string) error {
+	for _, p := range paths {
+		fi, err := os.Stat(p)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("mask %s error %v", p, err)
+		}
+		if fi.IsDir() {
+			err = syscall.Mount("tmpfs", p, "tmpfs", syscall.MS_RDONLY, "")
+		} else {
+			err = syscall.Mount("/dev/null", p, "bind", syscall.MS_BIND, "")
+		}
+		if err != nil {
+			return fmt.Errorf("mask %s error %v", p, err)
+		}
+	}
+	return nil
+}
+
+// 把路径 bind mount 到自身再重新挂载为只读，不存在的路径跳过
+func readonlyPaths(paths []string) error {
+	for _, p := range paths {
+		if _, err := os.Stat(p); os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return fmt.Errorf("stat %s error %v", p, err)
+		}
+		if err := syscall.Mount(p, p, "bind", syscall.MS_BIND|syscall.MS_REC, ""); err != nil {
+			return fmt.Errorf("bind mount %s error %v", p, err)
+		}
+		if err := remountReadonly(p); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// 重新挂载为只读，需要保留原来的 nosuid、nodev、noexec 等标志，
+// 否则在 user namespace 中会因为试图清除被锁定的标志而失败
+func remountReadonly(p string) error {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(p, &st); err != nil {
+		return err
+	}
+	keep := uintptr(syscall.MS_NOSUID | syscall.MS_NODEV | syscall.MS_NOEXEC | syscall.MS_SYNCHRONOUS |
+		syscall.MS_MANDLOCK | syscall.MS_NOATIME | syscall.MS_NODIRATIME | syscall.MS_RELATIME)
+	flags := uintptr(st.Flags)&keep | syscall.MS_BIND | syscall.MS_REMOUNT | syscall.MS_RDONLY
+	if err := syscall.Mount(p, p, "", flags, ""); err != nil {
+		return fmt.Errorf("remount %s read-only error %v", p, err)
+	}
+	return nil
+}
+
+// --read-only：先在 /tmp、/run 上挂载 tmpfs，再把根目录重新挂载为只读
+func setUpReadonlyRootfs() error {
+	for _, p := range ReadonlyTmpfsPaths {
+		if err := os.MkdirAll(p, 0755); err != nil {
+			return err
+		}
+		if err := syscall.Mount("tmpfs", p, "tmpfs", syscall.MS_NOSUID|syscall.MS_NODEV, "mode=1777"); err != nil {
+			return fmt.Errorf("mount tmpfs on %s error %v", p, err)
+		}
+	}
+	return remountReadonly("/")
+}
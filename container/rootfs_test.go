@@ -0,0 +1,58 @@
+package container
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSecurePath(t *testing.T) {
+	root, err := ioutil.TempDir("", "mydocker-rootfs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	for _, dir := range []string{"usr/lib", "etc"} {
+		if err := os.MkdirAll(filepath.Join(root, dir), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	links := map[string]string{
+		"evil":    "/etc",          // 绝对路径，在宿主机上会解析成宿主机的 /etc
+		"self":    "/self",         // 指向自己，在宿主机上解析时同样会跳到宿主机的路径
+		"up":      "../../../..",   // 超出 rootfs 的相对路径
+		"lib":     "usr/lib",       // 普通的相对路径
+		"abslib":  "/usr/lib",      // 普通的绝对路径
+		"hostetc": "/../../../etc", // 绝对路径中的 ..
+	}
+	for name, target := range links {
+		if err := os.Symlink(target, filepath.Join(root, name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := map[string]string{
+		"/evil":                "/etc",
+		"/evil/resolv.conf":    "/etc/resolv.conf",
+		"/up/etc/passwd":       "/etc/passwd",
+		"/lib/x":               "/usr/lib/x",
+		"/abslib/../bin":       "/usr/bin",
+		"/hostetc/hosts":       "/etc/hosts",
+		"/../../dev":           "/dev",
+		"/usr/./lib/../lib//y": "/usr/lib/y",
+	}
+	for unsafePath, expected := range tests {
+		got, err := securePath(root, unsafePath)
+		if err != nil {
+			t.Errorf("securePath(%q) error: %v", unsafePath, err)
+			continue
+		}
+		if want := filepath.Join(root, expected); got != want {
+			t.Errorf("securePath(%q): expected %s, got %s", unsafePath, want, got)
+		}
+	}
+	if _, err := securePath(root, "/self/resolv.conf"); err == nil {
+		t.Errorf("expected error for symlink loop")
+	}
+}
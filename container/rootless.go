@@ -0,0 +1,45 @@
+package container
+
+import (
+	"fmt"
+	"os"
+	"path"
+)
+
+// 是否以普通用户身份运行（rootless 模式）
+func IsRootless() bool {
+	return os.Geteuid() != 0
+}
+
+// rootless 模式下普通用户没有权限写 /var/run 和 /root，改为使用用户自己的目录：
+// 1.容器信息保存在 $XDG_RUNTIME_DIR/mydocker 下，没有设置时使用 /tmp/mydocker-uid
+// 2.镜像、读写层、挂载点保存在 $XDG_DATA_HOME/mydocker 下，没有设置时使用 ~/.local/share/mydocker
+func SetupRootlessPaths() error {
+	runtimeDir := os.Getenv("XDG_RUNTIME_DIR")
+	if runtimeDir == "" {
+		runtimeDir = path.Join(os.TempDir(), fmt.Sprintf("mydocker-%d", os.Getuid()))
+	} else {
+		runtimeDir = path.Join(runtimeDir, "mydocker")
+	}
+	dataDir := os.Getenv("XDG_DATA_HOME")
+	if dataDir == "" {
+		home := os.Getenv("HOME")
+		if home == "" {
+			return fmt.Errorf("neither XDG_DATA_HOME nor HOME is set")
+		}
+		dataDir = path.Join(home, ".local", "share")
+	}
+	dataDir = path.Join(dataDir, "mydocker")
+	for _, dir := range []string{runtimeDir, dataDir} {
+		if err := os.MkdirAll(dir, 0700); err != nil {
+			return err
+		}
+	}
+
+	DefaultInfoLocation = runtimeDir + "/%s/"
+	RootUrl = dataDir
+	MntUrl = dataDir + "/mnt/%s"
+	WriteLayerUrl = dataDir + "/writeLayer/%s"
+	WorkLayerUrl = dataDir + "/workLayer/%s"
+	return nil
+}
@@ -0,0 +1,38 @@
+package container
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+
+	"github.com/kkBill/mydocker/seccomp"
+)
+
+// 容器实际使用的 seccomp 配置保存在容器信息目录下，exec 进程使用同样的配置
+var SeccompProfileFile string = "seccomp.json"
+
+// 保存容器的 seccomp 配置，profile 为 nil（unconfined）时不保存
+func SaveSeccompProfile(containerName string, profile *seccomp.Profile) error {
+	if profile == nil {
+		return nil
+	}
+	bytes, err := json.Marshal(profile)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path.Join(fmt.Sprintf(DefaultInfoLocation, containerName), SeccompProfileFile), bytes, 0600)
+}
+
+// 读取容器的 seccomp 配置，文件不存在表示容器是 unconfined 的，返回 nil
+func LoadSeccompProfile(containerName string) (*seccomp.Profile, error) {
+	bytes, err := ioutil.ReadFile(path.Join(fmt.Sprintf(DefaultInfoLocation, containerName), SeccompProfileFile))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return seccomp.ParseProfile(bytes)
+}
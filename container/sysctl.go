@@ -0,0 +1,72 @@
+package container
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+)
+
+// 容器默认创建的 namespace，不包括 user namespace 和 time namespace
+const DefaultCloneFlags = syscall.CLONE_NEWUTS | syscall.CLONE_NEWIPC | syscall.CLONE_NEWPID | syscall.CLONE_NEWNS | syscall.CLONE_NEWNET | cloneNewCgroup
+
+// 隔离在各个 namespace 中的 sysctl，只有容器创建了对应的 namespace 时才允许设置，否则会修改宿主机的配置
+var namespacedSysctls = []struct {
+	prefix string
+	flag   uintptr
+	name   string
+}{
+	{"net.", syscall.CLONE_NEWNET, "network"},
+	{"kernel.msg", syscall.CLONE_NEWIPC, "ipc"},
+	{"kernel.shm", syscall.CLONE_NEWIPC, "ipc"},
+	{"kernel.sem", syscall.CLONE_NEWIPC, "ipc"},
+	{"fs.mqueue.", syscall.CLONE_NEWIPC, "ipc"},
+}
+
+// 解析 --sysctl key=value，key 中的 / 统一为 .，并检查 key 是否隔离在 cloneFlags 创建的 namespace 中
+func ParseSysctls(specs []string, cloneFlags uintptr) (map[string]string, error) {
+	sysctls := map[string]string{}
+	for _, spec := range specs {
+		kv := strings.SplitN(spec, "=", 2)
+		if len(kv) != 2 || kv[0] == "" {
+			return nil, fmt.Errorf("invalid sysctl %q, must be key=value", spec)
+		}
+		key := strings.Replace(strings.TrimSpace(kv[0]), "/", ".", -1)
+		if err := validateSysctl(key, cloneFlags); err != nil {
+			return nil, err
+		}
+		sysctls[key] = kv[1]
+	}
+	return sysctls, nil
+}
+
+func validateSysctl(key string, cloneFlags uintptr) error {
+	for _, ns := range namespacedSysctls {
+		if !strings.HasPrefix(key, ns.prefix) {
+			continue
+		}
+		if cloneFlags&ns.flag == 0 {
+			return fmt.Errorf("sysctl %q is not allowed, the container shares the %s namespace with the host", key, ns.name)
+		}
+		return nil
+	}
+	return fmt.Errorf("sysctl %q is not allowed, only namespaced sysctls net.*, kernel.msg*, kernel.shm*, kernel.sem and fs.mqueue.* are supported", key)
+}
+
+// 在容器中写 /proc/sys 设置 sysctl，必须在 /proc/sys 重新挂载为只读之前调用
+func setSysctls(sysctls map[string]string) error {
+	keys := make([]string, 0, len(sysctls))
+	for key := range sysctls {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		file := filepath.Join("/proc/sys", strings.Replace(key, ".", "/", -1))
+		if err := ioutil.WriteFile(file, []byte(sysctls[key]), 0644); err != nil {
+			return fmt.Errorf("set sysctl %s error %v", key, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,29 @@
+package container
+
+import (
+	"syscall"
+	"testing"
+)
+
+func TestParseSysctls(t *testing.T) {
+	sysctls, err := ParseSysctls([]string{"net.core.somaxconn=1024", "net/ipv4/ip_local_port_range=1024 65000", "kernel.shmmax=0", "fs.mqueue.msg_max=20"}, DefaultCloneFlags)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sysctls["net.core.somaxconn"] != "1024" || sysctls["net.ipv4.ip_local_port_range"] != "1024 65000" {
+		t.Errorf("got %v", sysctls)
+	}
+
+	for _, spec := range []string{"kernel.hostname=x", "vm.swappiness=1", "net.core.somaxconn", "=1"} {
+		if _, err := ParseSysctls([]string{spec}, DefaultCloneFlags); err == nil {
+			t.Errorf("ParseSysctls(%q) expected error", spec)
+		}
+	}
+	// 与宿主机共享 network namespace 时不能设置 net.*
+	if _, err := ParseSysctls([]string{"net.core.somaxconn=1024"}, DefaultCloneFlags&^syscall.CLONE_NEWNET); err == nil {
+		t.Errorf("net sysctl without network namespace expected error")
+	}
+	if _, err := ParseSysctls([]string{"kernel.msgmax=1"}, DefaultCloneFlags&^syscall.CLONE_NEWIPC); err == nil {
+		t.Errorf("ipc sysctl without ipc namespace expected error")
+	}
+}
@@ -0,0 +1,75 @@
+package container
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 通过这个环境变量把时钟偏移传给容器 init 进程，由 nsenter 在 go 运行时启动之前创建 time namespace 并写入 /proc/self/timens_offsets
+const ENV_TIME_OFFSETS = "mydocker_time_offsets"
+
+// --time-offset 指定的时钟偏移，容器内的 CLOCK_MONOTONIC 和 CLOCK_BOOTTIME 在宿主机的基础上加上偏移
+type TimeOffsets struct {
+	Monotonic time.Duration `json:"monotonic"`
+	Boottime  time.Duration `json:"boottime"`
+}
+
+// 解析 monotonic=<偏移>,boottime=<偏移>，偏移为秒数或者 1h30m 这样的时长，可以为负数
+func ParseTimeOffsets(spec string) (*TimeOffsets, error) {
+	offsets := &TimeOffsets{}
+	for _, item := range strings.Split(spec, ",") {
+		kv := strings.SplitN(strings.TrimSpace(item), "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("invalid time offset %q, must be monotonic=<offset> or boottime=<offset>", item)
+		}
+		offset, err := ParseDuration(kv[1])
+		if err != nil {
+			return nil, fmt.Errorf("invalid time offset %q: %v", item, err)
+		}
+		switch kv[0] {
+		case "monotonic":
+			offsets.Monotonic = offset
+		case "boottime":
+			offsets.Boottime = offset
+		default:
+			return nil, fmt.Errorf("invalid time offset %q, clock must be monotonic or boottime", item)
+		}
+	}
+	return offsets, nil
+}
+
+// 解析秒数或者 1h30m 这样的时长
+func ParseDuration(s string) (time.Duration, error) {
+	if secs, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return time.Duration(secs) * time.Second, nil
+	}
+	return time.ParseDuration(s)
+}
+
+// 内核 5.6 之后才支持 time namespace
+func CheckTimeNamespace() error {
+	if _, err := os.Stat("/proc/self/ns/time"); err != nil {
+		return fmt.Errorf("time namespace is not supported by the running kernel")
+	}
+	return nil
+}
+
+// /proc/self/timens_offsets 的格式：每行为 时钟 秒 纳秒，纳秒必须在 [0, 1e9) 之间
+func (t *TimeOffsets) procFormat() string {
+	var lines []string
+	for _, clock := range []struct {
+		name   string
+		offset time.Duration
+	}{{"monotonic", t.Monotonic}, {"boottime", t.Boottime}} {
+		secs, nsecs := int64(clock.offset/time.Second), int64(clock.offset%time.Second)
+		if nsecs < 0 {
+			secs--
+			nsecs += int64(time.Second)
+		}
+		lines = append(lines, fmt.Sprintf("%s %d %d", clock.name, secs, nsecs))
+	}
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,24 @@
+package container
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseTimeOffsets(t *testing.T) {
+	offsets, err := ParseTimeOffsets("monotonic=86400,boottime=-1.5s")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if offsets.Monotonic != 24*time.Hour || offsets.Boottime != -1500*time.Millisecond {
+		t.Errorf("got %+v", offsets)
+	}
+	if got, want := offsets.procFormat(), "monotonic 86400 0\nboottime -2 500000000"; got != want {
+		t.Errorf("proc format: got %q, want %q", got, want)
+	}
+	for _, spec := range []string{"monotonic", "realtime=10", "boottime=abc", ""} {
+		if _, err := ParseTimeOffsets(spec); err == nil {
+			t.Errorf("%q expected error", spec)
+		}
+	}
+}
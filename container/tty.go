@@ -0,0 +1,109 @@
+package container
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+// 通过 /dev/ptmx 创建一对伪终端，返回 master 端和 slave 端
+// master 端留在宿主机上的 mydocker 进程中，slave 端作为容器内进程的标准输入输出
+func NewPty() (*os.File, *os.File, error) {
+	master, err := os.OpenFile("/dev/ptmx", os.O_RDWR|syscall.O_NOCTTY|syscall.O_CLOEXEC, 0)
+	if err != nil {
+		return nil, nil, err
+	}
+	// 解锁 slave 端
+	var unlock int32
+	if err := ioctl(master.Fd(), syscall.TIOCSPTLCK, uintptr(unsafe.Pointer(&unlock))); err != nil {
+		master.Close()
+		return nil, nil, fmt.Errorf("unlockpt error %v", err)
+	}
+	// 获取 slave 端的编号，即 /dev/pts/N
+	var ptyNum uint32
+	if err := ioctl(master.Fd(), syscall.TIOCGPTN, uintptr(unsafe.Pointer(&ptyNum))); err != nil {
+		master.Close()
+		return nil, nil, fmt.Errorf("ptsname error %v", err)
+	}
+	slavePath := fmt.Sprintf("/dev/pts/%d", ptyNum)
+	slave, err := os.OpenFile(slavePath, os.O_RDWR|syscall.O_NOCTTY, 0)
+	if err != nil {
+		master.Close()
+		return nil, nil, err
+	}
+	return master, slave, nil
+}
+
+// 判断文件描述符是否是一个终端
+func IsTerminal(fd uintptr) bool {
+	var termios syscall.Termios
+	return ioctl(fd, syscall.TCGETS, uintptr(unsafe.Pointer(&termios))) == nil
+}
+
+// 把终端设置为 raw 模式，返回原来的终端状态，用于之后恢复
+// raw 模式下输入的字符不再由宿主机终端处理（回显、Ctrl-C 等），而是原样交给容器内的终端
+func SetRawTerminal(fd uintptr) (*syscall.Termios, error) {
+	var oldState syscall.Termios
+	if err := ioctl(fd, syscall.TCGETS, uintptr(unsafe.Pointer(&oldState))); err != nil {
+		return nil, err
+	}
+	newState := oldState
+	// 与 cfmakeraw(3) 的设置一致
+	newState.Iflag &^= syscall.IGNBRK | syscall.BRKINT | syscall.PARMRK | syscall.ISTRIP | syscall.INLCR | syscall.IGNCR | syscall.ICRNL | syscall.IXON
+	newState.Oflag &^= syscall.OPOST
+	newState.Lflag &^= syscall.ECHO | syscall.ECHONL | syscall.ICANON | syscall.ISIG | syscall.IEXTEN
+	newState.Cflag &^= syscall.CSIZE | syscall.PARENB
+	newState.Cflag |= syscall.CS8
+	newState.Cc[syscall.VMIN] = 1
+	newState.Cc[syscall.VTIME] = 0
+	if err := ioctl(fd, syscall.TCSETS, uintptr(unsafe.Pointer(&newState))); err != nil {
+		return nil, err
+	}
+	return &oldState, nil
+}
+
+// 恢复终端原来的状态
+func RestoreTerminal(fd uintptr, state *syscall.Termios) error {
+	if state == nil {
+		return nil
+	}
+	return ioctl(fd, syscall.TCSETS, uintptr(unsafe.Pointer(state)))
+}
+
+// 把 fd 对应的终端设置为当前进程的控制终端
+func SetControllingTerminal(fd uintptr) error {
+	if _, err := syscall.Setsid(); err != nil {
+		return fmt.Errorf("setsid error %v", err)
+	}
+	return ioctl(fd, syscall.TIOCSCTTY, 0)
+}
+
+// 终端窗口的大小，对应内核中的 struct winsize
+type Winsize struct {
+	Rows   uint16
+	Cols   uint16
+	XPixel uint16
+	YPixel uint16
+}
+
+// 获取终端窗口的大小
+func GetWinsize(fd uintptr) (*Winsize, error) {
+	ws := &Winsize{}
+	if err := ioctl(fd, syscall.TIOCGWINSZ, uintptr(unsafe.Pointer(ws))); err != nil {
+		return nil, err
+	}
+	return ws, nil
+}
+
+// 设置终端窗口的大小，对 pty 的 master 端设置后，内核会向容器内的前台进程组发送 SIGWINCH
+func SetWinsize(fd uintptr, ws *Winsize) error {
+	return ioctl(fd, syscall.TIOCSWINSZ, uintptr(unsafe.Pointer(ws)))
+}
+
+func ioctl(fd, req, arg uintptr) error {
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, fd, req, arg); errno != 0 {
+		return errno
+	}
+	return nil
+}
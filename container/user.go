@@ -0,0 +1,211 @@
+package container
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// 容器内 /etc/passwd、/etc/group 的路径，切换用户时容器的根目录已经是 rootfs 了
+var (
+	PasswdFile string = "/etc/passwd"
+	GroupFile  string = "/etc/group"
+)
+
+// 解析出来的用户信息
+type ExecUser struct {
+	Uid   int
+	Gid   int
+	Sgids []int  //附加组
+	Home  string //用户的 home 目录
+}
+
+// /etc/passwd 中的一行：name:password:uid:gid:gecos:home:shell
+type passwdEntry struct {
+	name string
+	uid  int
+	gid  int
+	home string
+}
+
+// /etc/group 中的一行：name:password:gid:member1,member2
+type groupEntry struct {
+	name    string
+	gid     int
+	members []string
+}
+
+// 解析 name|uid[:group|gid] 格式的用户参数：
+// 1.用户名和组名从容器的 /etc/passwd、/etc/group 中查找，数字形式的 uid/gid 找不到时直接使用
+// 2.未指定组时使用 /etc/passwd 中的主组，数字 uid 在 passwd 中不存在时 gid 与 uid 相同
+// 3./etc/group 中成员包含该用户的组都作为附加组
+func ResolveUser(user, passwdPath, groupPath string) (*ExecUser, error) {
+	parts := strings.SplitN(user, ":", 2)
+	userPart := parts[0]
+	if userPart == "" {
+		return nil, fmt.Errorf("invalid user %q", user)
+	}
+	passwd, err := readPasswd(passwdPath)
+	if err != nil {
+		return nil, err
+	}
+	groups, err := readGroup(groupPath)
+	if err != nil {
+		return nil, err
+	}
+
+	execUser := &ExecUser{Home: "/"}
+	var userName string
+	uid, uidErr := strconv.Atoi(userPart)
+	found := false
+	for _, p := range passwd {
+		if uidErr == nil && p.uid == uid || uidErr != nil && p.name == userPart {
+			execUser.Uid, execUser.Gid, execUser.Home = p.uid, p.gid, p.home
+			userName = p.name
+			found = true
+			break
+		}
+	}
+	if !found {
+		if uidErr != nil {
+			return nil, fmt.Errorf("unable to find user %s: no matching entries in passwd file", userPart)
+		}
+		execUser.Uid, execUser.Gid = uid, uid
+	}
+
+	if len(parts) == 2 {
+		groupPart := parts[1]
+		gid, gidErr := strconv.Atoi(groupPart)
+		found := false
+		for _, g := range groups {
+			if gidErr == nil && g.gid == gid || gidErr != nil && g.name == groupPart {
+				execUser.Gid = g.gid
+				found = true
+				break
+			}
+		}
+		if !found {
+			if gidErr != nil {
+				return nil, fmt.Errorf("unable to find group %s: no matching entries in group file", groupPart)
+			}
+			execUser.Gid = gid
+		}
+	}
+
+	if userName != "" {
+		for _, g := range groups {
+			for _, member := range g.members {
+				if member == userName && g.gid != execUser.Gid {
+					execUser.Sgids = append(execUser.Sgids, g.gid)
+					break
+				}
+			}
+		}
+	}
+	return execUser, nil
+}
+
+// 文件不存在时当作空文件处理，镜像里不一定有 /etc/passwd
+func readColonFile(filePath string, minFields int, handle func(fields []string) error) error {
+	file, err := os.Open(filePath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Split(line, ":")
+		if len(fields) < minFields {
+			continue
+		}
+		if err := handle(fields); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
+
+func readPasswd(filePath string) ([]passwdEntry, error) {
+	var entries []passwdEntry
+	err := readColonFile(filePath, 4, func(fields []string) error {
+		uid, err1 := strconv.Atoi(fields[2])
+		gid, err2 := strconv.Atoi(fields[3])
+		if err1 != nil || err2 != nil {
+			return nil
+		}
+		entry := passwdEntry{name: fields[0], uid: uid, gid: gid, home: "/"}
+		if len(fields) > 5 && fields[5] != "" {
+			entry.home = fields[5]
+		}
+		entries = append(entries, entry)
+		return nil
+	})
+	return entries, err
+}
+
+func readGroup(filePath string) ([]groupEntry, error) {
+	var entries []groupEntry
+	err := readColonFile(filePath, 3, func(fields []string) error {
+		gid, err := strconv.Atoi(fields[2])
+		if err != nil {
+			return nil
+		}
+		entry := groupEntry{name: fields[0], gid: gid}
+		if len(fields) > 3 && fields[3] != "" {
+			entry.members = strings.Split(fields[3], ",")
+		}
+		entries = append(entries, entry)
+		return nil
+	})
+	return entries, err
+}
+
+// 切换当前进程的用户，必须先设置附加组和 gid 再设置 uid，否则切换 uid 后就没有权限再修改了
+// user 为空时不切换用户，返回 nil
+func setupUser(user string) (*ExecUser, error) {
+	if user == "" {
+		return nil, nil
+	}
+	execUser, err := ResolveUser(user, PasswdFile, GroupFile)
+	if err != nil {
+		return nil, err
+	}
+	sgids := execUser.Sgids
+	if sgids == nil {
+		sgids = []int{}
+	}
+	if err := syscall.Setgroups(sgids); err != nil {
+		return nil, fmt.Errorf("setgroups %v error %v, make sure the gids are mapped in the user namespace", sgids, err)
+	}
+	if err := syscall.Setgid(execUser.Gid); err != nil {
+		return nil, fmt.Errorf("setgid %d error %v, make sure the gid is mapped in the user namespace", execUser.Gid, err)
+	}
+	if err := syscall.Setuid(execUser.Uid); err != nil {
+		return nil, fmt.Errorf("setuid %d error %v, make sure the uid is mapped in the user namespace", execUser.Uid, err)
+	}
+	return execUser, nil
+}
+
+// 环境变量中没有 HOME 时，设置为用户的 home 目录
+func setHomeEnv(env []string, execUser *ExecUser) []string {
+	for _, e := range env {
+		if strings.HasPrefix(e, "HOME=") {
+			return env
+		}
+	}
+	home := "/"
+	if execUser != nil {
+		home = execUser.Home
+	}
+	return append(env, "HOME="+home)
+}
@@ -0,0 +1,45 @@
+package container
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"reflect"
+	"testing"
+)
+
+func TestResolveUser(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mydocker-user")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	passwdPath := path.Join(dir, "passwd")
+	groupPath := path.Join(dir, "group")
+	ioutil.WriteFile(passwdPath, []byte("root:x:0:0:root:/root:/bin/sh\napp:x:1000:1000:app:/home/app:/bin/sh\n"), 0644)
+	ioutil.WriteFile(groupPath, []byte("root:x:0:\napp:x:1000:\nwheel:x:10:root,app\naudio:x:29:app\n"), 0644)
+
+	tests := map[string]ExecUser{
+		"app":       {Uid: 1000, Gid: 1000, Sgids: []int{10, 29}, Home: "/home/app"},
+		"1000":      {Uid: 1000, Gid: 1000, Sgids: []int{10, 29}, Home: "/home/app"},
+		"app:wheel": {Uid: 1000, Gid: 10, Sgids: []int{29}, Home: "/home/app"},
+		"root:1000": {Uid: 0, Gid: 1000, Sgids: []int{10}, Home: "/root"},
+		"2000":      {Uid: 2000, Gid: 2000, Home: "/"},
+		"2000:3000": {Uid: 2000, Gid: 3000, Home: "/"},
+	}
+	for user, expected := range tests {
+		got, err := ResolveUser(user, passwdPath, groupPath)
+		if err != nil {
+			t.Errorf("resolve %s error: %v", user, err)
+			continue
+		}
+		if !reflect.DeepEqual(*got, expected) {
+			t.Errorf("resolve %s: expected %+v, got %+v", user, expected, *got)
+		}
+	}
+	for _, user := range []string{"nobody", "app:staff", ""} {
+		if _, err := ResolveUser(user, passwdPath, groupPath); err == nil {
+			t.Errorf("expected error for user %q", user)
+		}
+	}
+}
@@ -0,0 +1,232 @@
+package container
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// --userns 的取值，host 表示不创建 user namespace
+const UsernsHost = "host"
+
+// 需要由 newuidmap/newgidmap 写入映射时，通过这个环境变量告诉容器 init 进程先等待映射写好
+const ENV_USERNS_SYNC = "mydocker_userns_sync"
+
+// 从属 id 的配置文件
+var (
+	SubuidFile string = "/etc/subuid"
+	SubgidFile string = "/etc/subgid"
+)
+
+// 容器的 user namespace 配置
+type UsernsConfig struct {
+	Host        bool                   `json:"host"`        //不创建 user namespace，与宿主机共用
+	Remap       string                 `json:"remap"`       //--userns-remap 指定的用户，为空表示只映射 root
+	UidMappings []syscall.SysProcIDMap `json:"uidMappings"` //容器内 uid 到宿主机 uid 的映射
+	GidMappings []syscall.SysProcIDMap `json:"gidMappings"` //容器内 gid 到宿主机 gid 的映射
+}
+
+// 根据 --userns 和 --userns-remap 生成 user namespace 配置：
+// 1.--userns=host 时不创建 user namespace
+// 2.--userns-remap=user[:group] 时把容器内从 0 开始的 id 依次映射到 /etc/subuid、/etc/subgid 中该用户的所有区间
+// 3.默认只把容器内的 root 映射为当前用户
+func NewUsernsConfig(userns, remap string) (*UsernsConfig, error) {
+	switch userns {
+	case "":
+	case UsernsHost:
+		if IsRootless() {
+			return nil, fmt.Errorf("--userns=host is not supported in rootless mode")
+		}
+		if remap != "" {
+			return nil, fmt.Errorf("--userns=host can not be used with --userns-remap")
+		}
+		return &UsernsConfig{Host: true}, nil
+	default:
+		return nil, fmt.Errorf("invalid userns mode %q, only %q is supported", userns, UsernsHost)
+	}
+	if remap == "" {
+		return &UsernsConfig{
+			UidMappings: []syscall.SysProcIDMap{{ContainerID: 0, HostID: os.Getuid(), Size: 1}},
+			GidMappings: []syscall.SysProcIDMap{{ContainerID: 0, HostID: os.Getgid(), Size: 1}},
+		}, nil
+	}
+
+	userName, groupName := remap, remap
+	if parts := strings.SplitN(remap, ":", 2); len(parts) == 2 {
+		userName, groupName = parts[0], parts[1]
+	}
+	// 非 root 用户只能通过 newuidmap 写入映射，容器内的 root 映射为自己，从属 id 从 1 开始
+	// 这样 init 进程重新 exec 之后才是容器内的 root
+	first := 0
+	if os.Geteuid() != 0 {
+		first = 1
+	}
+	uidMappings, err := readSubIDMappings(SubuidFile, userName, lookupUid, first)
+	if err != nil {
+		return nil, err
+	}
+	gidMappings, err := readSubIDMappings(SubgidFile, groupName, lookupGid, first)
+	if err != nil {
+		return nil, err
+	}
+	if first == 1 {
+		uidMappings = append([]syscall.SysProcIDMap{{ContainerID: 0, HostID: os.Getuid(), Size: 1}}, uidMappings...)
+		gidMappings = append([]syscall.SysProcIDMap{{ContainerID: 0, HostID: os.Getgid(), Size: 1}}, gidMappings...)
+	}
+	return &UsernsConfig{Remap: remap, UidMappings: uidMappings, GidMappings: gidMappings}, nil
+}
+
+// 是否需要借助 newuidmap/newgidmap 写入映射，非 root 用户不能直接写 uid_map 中的从属 id 区间
+func (c *UsernsConfig) NeedNewIDMap() bool {
+	return !c.Host && c.Remap != "" && os.Geteuid() != 0
+}
+
+// 把容器内的 id 转换为宿主机上的 id，不在映射范围内时返回 -1
+func hostID(mappings []syscall.SysProcIDMap, id int) int {
+	for _, m := range mappings {
+		if id >= m.ContainerID && id < m.ContainerID+m.Size {
+			return m.HostID + id - m.ContainerID
+		}
+	}
+	return -1
+}
+
+// 容器内 root 对应的宿主机 uid 和 gid
+func (c *UsernsConfig) RootPair() (int, int) {
+	if c.Host {
+		return 0, 0
+	}
+	return hostID(c.UidMappings, 0), hostID(c.GidMappings, 0)
+}
+
+func lookupUid(name string) (string, error) {
+	u, err := user.Lookup(name)
+	if err != nil {
+		return "", err
+	}
+	return u.Uid, nil
+}
+
+func lookupGid(name string) (string, error) {
+	g, err := user.LookupGroup(name)
+	if err != nil {
+		return "", err
+	}
+	return g.Gid, nil
+}
+
+// 读取 /etc/subuid 或 /etc/subgid 中 name:start:count 格式的区间，文件中也可以用数字 id 代替名字
+// 多个区间按顺序拼接，容器内的 id 从 first 开始连续分配
+func readSubIDMappings(filePath, name string, lookupID func(string) (string, error), first int) ([]syscall.SysProcIDMap, error) {
+	id := name
+	if _, err := strconv.Atoi(name); err != nil {
+		if id, err = lookupID(name); err != nil {
+			id = ""
+		}
+	}
+	file, err := os.Open(filePath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var mappings []syscall.SysProcIDMap
+	containerID := first
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Split(strings.TrimSpace(scanner.Text()), ":")
+		if len(fields) != 3 || fields[0] != name && fields[0] != id {
+			continue
+		}
+		start, err1 := strconv.Atoi(fields[1])
+		count, err2 := strconv.Atoi(fields[2])
+		if err1 != nil || err2 != nil || count <= 0 {
+			return nil, fmt.Errorf("invalid line %q in %s", scanner.Text(), filePath)
+		}
+		mappings = append(mappings, syscall.SysProcIDMap{ContainerID: containerID, HostID: start, Size: count})
+		containerID += count
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(mappings) == 0 {
+		return nil, fmt.Errorf("no subordinate ids for %s in %s", name, filePath)
+	}
+	return mappings, nil
+}
+
+// 通过 setuid 的 newuidmap/newgidmap 写入容器进程的 uid_map 和 gid_map
+func WriteIDMappings(pid int, uidMappings, gidMappings []syscall.SysProcIDMap) error {
+	if err := runIDMapTool("newuidmap", pid, uidMappings); err != nil {
+		return err
+	}
+	return runIDMapTool("newgidmap", pid, gidMappings)
+}
+
+func runIDMapTool(tool string, pid int, mappings []syscall.SysProcIDMap) error {
+	args := []string{strconv.Itoa(pid)}
+	for _, m := range mappings {
+		args = append(args, strconv.Itoa(m.ContainerID), strconv.Itoa(m.HostID), strconv.Itoa(m.Size))
+	}
+	if output, err := exec.Command(tool, args...).CombinedOutput(); err != nil {
+		return fmt.Errorf("%s %s error %v: %s", tool, strings.Join(args, " "), err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+// 映射后的容器 root 在宿主机上只是一个普通用户，为了能找到 rootfs 和容器信息目录，
+// 给这些目录及其所有上级目录加上其他用户的搜索权限（o+x），只能按路径访问，不能列出目录内容，与 docker 对 /var/lib/docker 的处理一致
+func AllowTraverse(dir string) error {
+	for dir = filepath.Clean(dir); ; dir = filepath.Dir(dir) {
+		info, err := os.Stat(dir)
+		if err != nil {
+			return err
+		}
+		if info.Mode()&0001 == 0 {
+			if err := os.Chmod(dir, info.Mode()|0001); err != nil {
+				return err
+			}
+		}
+		if dir == "/" {
+			return nil
+		}
+	}
+}
+
+// 把目录中的文件属主从容器内的 id 平移到映射后的宿主机 id，容器内的 root 才能修改这些文件
+// 已经在映射范围之外的 id（比如已经平移过的）保持不变
+func ShiftOwnership(dir string, uidMappings, gidMappings []syscall.SysProcIDMap) error {
+	return filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		stat, ok := info.Sys().(*syscall.Stat_t)
+		if !ok {
+			return nil
+		}
+		uid, gid := hostID(uidMappings, int(stat.Uid)), hostID(gidMappings, int(stat.Gid))
+		if uid < 0 {
+			uid = int(stat.Uid)
+		}
+		if gid < 0 {
+			gid = int(stat.Gid)
+		}
+		if uid == int(stat.Uid) && gid == int(stat.Gid) {
+			return nil
+		}
+		if err := os.Lchown(path, uid, gid); err != nil {
+			return err
+		}
+		// chown 会清除 setuid/setgid 位，需要恢复原来的权限
+		if info.Mode()&(os.ModeSetuid|os.ModeSetgid) != 0 && info.Mode()&os.ModeSymlink == 0 {
+			return os.Chmod(path, info.Mode())
+		}
+		return nil
+	})
+}
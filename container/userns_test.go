@@ -0,0 +1,51 @@
+package container
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"reflect"
+	"syscall"
+	"testing"
+)
+
+func TestReadSubIDMappings(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mydocker-subuid")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	subuidPath := path.Join(dir, "subuid")
+	ioutil.WriteFile(subuidPath, []byte("other:200000:65536\nremap:100000:65536\n1001:300000:1000\nremap:500000:10\n"), 0644)
+
+	noLookup := func(string) (string, error) { return "1001", nil }
+	mappings, err := readSubIDMappings(subuidPath, "remap", noLookup, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []syscall.SysProcIDMap{
+		{ContainerID: 0, HostID: 100000, Size: 65536},
+		{ContainerID: 65536, HostID: 300000, Size: 1000},
+		{ContainerID: 66536, HostID: 500000, Size: 10},
+	}
+	if !reflect.DeepEqual(mappings, expected) {
+		t.Errorf("expected %v, got %v", expected, mappings)
+	}
+	if id := hostID(mappings, 65537); id != 300001 {
+		t.Errorf("expected host id 300001, got %d", id)
+	}
+	if id := hostID(mappings, 70000); id != -1 {
+		t.Errorf("expected unmapped id, got %d", id)
+	}
+
+	mappings, err = readSubIDMappings(subuidPath, "other", noLookup, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mappings[0].ContainerID != 1 {
+		t.Errorf("expected mappings to start at container id 1, got %v", mappings)
+	}
+	if _, err := readSubIDMappings(subuidPath, "nobody", func(string) (string, error) { return "", os.ErrNotExist }, 0); err == nil {
+		t.Errorf("expected error for user without subordinate ids")
+	}
+}
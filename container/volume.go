@@ -19,10 +19,19 @@ import (
 // 执行数据卷挂载的命令：./mydocker run -ti -v /root/volume:/containerVolume sh
 
 // 为每个容器创建文件系统
-func NewWorkSpace(volume, imageName, containerName string) {
-	CreateReadOnlyLayer(imageName)
-	CreateWriteLayer(containerName)
-	CreateMountPoint(containerName, imageName)
+// 使用 --userns-remap 时，只读层和读写层的文件属主要平移到映射后的 id 上
+func NewWorkSpace(volume, imageName, containerName string, userns *UsernsConfig) {
+	CreateReadOnlyLayer(imageName, userns)
+	CreateWriteLayer(containerName, userns)
+	// rootless 模式下宿主机上没有权限挂载，只创建目录，由容器 init 进程在 user namespace 中挂载 overlay
+	if IsRootless() {
+		CreateRootlessMountPoint(containerName)
+		if volume != "" {
+			logrus.Warnf("NewWorkSpace: volumes are not supported in rootless mode, ignore %s", volume)
+		}
+		return
+	}
+	CreateMountPoint(containerName, imageLayerURL(imageName, userns))
 
 	// 根据volume是否为空判断是否执行挂载数据卷操作
 	if volume != "" {
@@ -36,10 +45,20 @@ func NewWorkSpace(volume, imageName, containerName string) {
 	}
 }
 
+// 镜像解压后的只读层目录
+// 不同的 id 映射需要不同属主的只读层，解压到 /root/宿主机uid.宿主机gid/镜像名 下，与 docker 的做法一致
+func imageLayerURL(imageName string, userns *UsernsConfig) string {
+	if userns == nil || userns.Remap == "" {
+		return RootUrl + "/" + imageName
+	}
+	uid, gid := userns.RootPair()
+	return fmt.Sprintf("%s/%d.%d/%s", RootUrl, uid, gid, imageName)
+}
+
 // 根据用户输入的镜像为每个容器创建只读层
 // 解压tar格式的镜像文件，作为容器的只读层
-func CreateReadOnlyLayer(imageName string) error {
-	unTarFolderURL := RootUrl + "/" + imageName + "/"
+func CreateReadOnlyLayer(imageName string, userns *UsernsConfig) error {
+	unTarFolderURL := imageLayerURL(imageName, userns) + "/"
 	imageURL := RootUrl + "/" + imageName + ".tar"
 
 	exists, err := PathExists(unTarFolderURL)
@@ -58,21 +77,32 @@ func CreateReadOnlyLayer(imageName string) error {
 			logrus.Errorf("CreateReadOnlyLayer: unTar dir %s error %v", imageURL, err)
 			return err
 		}
+		if userns != nil && userns.Remap != "" {
+			if err := ShiftOwnership(unTarFolderURL, userns.UidMappings, userns.GidMappings); err != nil {
+				logrus.Errorf("CreateReadOnlyLayer: shift ownership of %s error %v", unTarFolderURL, err)
+				return err
+			}
+		}
 	}
 	return nil
 }
 
 // 创建一个名为writeLayer的文件夹作为容器唯一的可写层
 // 为每个容器创建一个读写层
-func CreateWriteLayer(containerName string) {
+func CreateWriteLayer(containerName string, userns *UsernsConfig) {
 	writeURL := fmt.Sprintf(WriteLayerUrl, containerName)
 	if err := os.MkdirAll(writeURL, 0777); err != nil {
 		logrus.Errorf("CreateWriteLayer: Mkdir %s error. %v", writeURL, err)
 	}
+	if userns != nil && userns.Remap != "" {
+		if err := ShiftOwnership(writeURL, userns.UidMappings, userns.GidMappings); err != nil {
+			logrus.Errorf("CreateWriteLayer: shift ownership of %s error %v", writeURL, err)
+		}
+	}
 }
 
-// 创建mnt文件夹作为挂载点
-func CreateMountPoint(containerName, imageName string) error {
+// 创建mnt文件夹作为挂载点，imageLocation 是镜像只读层的目录
+func CreateMountPoint(containerName, imageLocation string) error {
 	mntURL := fmt.Sprintf(MntUrl, containerName)
 	if err := os.MkdirAll(mntURL, 0777); err != nil {
 		logrus.Errorf("CreateMountPoint: Mkdir %s error. %v", mntURL, err)
@@ -80,7 +110,7 @@ func CreateMountPoint(containerName, imageName string) error {
 	}
 	// 把writeLayer目录和busybox目录mount到mnt目录下
 	tmpWriteLayer := fmt.Sprintf(WriteLayerUrl, containerName)
-	tmpImageLocation := RootUrl + "/" + imageName
+	tmpImageLocation := imageLocation
 
 	dirs := "dirs=" + tmpWriteLayer + ":" + tmpImageLocation
 	cmd := exec.Command("mount", "-t", "aufs", "-o", dirs, "none", mntURL)
@@ -93,6 +123,27 @@ func CreateMountPoint(containerName, imageName string) error {
 	return nil
 }
 
+// rootless 模式下创建挂载点和 overlay 需要的 workdir
+func CreateRootlessMountPoint(containerName string) error {
+	for _, dir := range []string{fmt.Sprintf(MntUrl, containerName), fmt.Sprintf(WorkLayerUrl, containerName)} {
+		if err := os.MkdirAll(dir, 0777); err != nil {
+			logrus.Errorf("CreateRootlessMountPoint: Mkdir %s error. %v", dir, err)
+			return err
+		}
+	}
+	return nil
+}
+
+// rootless 模式下容器 init 进程挂载 rootfs 使用的 overlay 参数，非 rootless 模式返回空
+// 较新的内核（5.11+）允许在 user namespace 中挂载 overlay
+func RootlessOverlayOptions(imageName, containerName string, userns *UsernsConfig) string {
+	if !IsRootless() {
+		return ""
+	}
+	return fmt.Sprintf("lowerdir=%s,upperdir=%s,workdir=%s", imageLayerURL(imageName, userns),
+		fmt.Sprintf(WriteLayerUrl, containerName), fmt.Sprintf(WorkLayerUrl, containerName))
+}
+
 // 挂载数据卷，其基本过程如下：
 // 1.读取宿主机文件目录hostURL，创建宿主机文件目录/root/${hostURL}
 // 2.读取容器挂载点containerURL，在容器文件系统里创建挂载点/root/mnt/containerName/${containerURL}
@@ -201,6 +252,10 @@ func DeleteMountPointWithVolume(volumeURLs []string, containerName string) error
 // 先umount，再删除相应的文件夹
 func DeleteMountPoint(containerName string) error {
 	mntURL := fmt.Sprintf(MntUrl, containerName)
+	// rootless 模式下 overlay 挂载在容器的 mount namespace 中，容器退出后自动卸载
+	if IsRootless() {
+		return os.RemoveAll(mntURL)
+	}
 	cmd := exec.Command("umount", mntURL)
 
 	if err := cmd.Run(); err != nil {
@@ -219,4 +274,8 @@ func DeleteWriteLayer(containerName string) {
 	if err := os.RemoveAll(writeURL); err != nil {
 		logrus.Errorf("remove dir %s error. %v", writeURL, err)
 	}
+	workURL := fmt.Sprintf(WorkLayerUrl, containerName)
+	if err := os.RemoveAll(workURL); err != nil {
+		logrus.Errorf("remove dir %s error. %v", workURL, err)
+	}
 }
@@ -4,40 +4,207 @@ import (
 	"encoding/json"
 	"fmt"
 	"github.com/Sirupsen/logrus"
+	"github.com/kkBill/mydocker/cgroup"
 	"github.com/kkBill/mydocker/container"
 	"io/ioutil"
 	"os"
 	"os/exec"
+	"path"
 	"strings"
+	"syscall"
+	"time"
 )
 
 const ENV_EXEC_PID = "mydocker_pid"
-const ENV_EXEC_CMD = "mydocker_cmd"
 
-func ExecContainer(containerName string, commandArray []string) {
+// 告诉 nsenter 同时进入容器的 user namespace
+const ENV_EXEC_JOIN_USERNS = "mydocker_join_userns"
+
+// 基本逻辑：
+// 1.启动 /proc/self/exe exec 子进程，并通过环境变量 mydocker_pid 告诉 nsenter 要进入哪个容器的 namespace
+// 2.nsenter 在 go 运行时启动之前完成 setns 和 fork，子进程回到 go 中执行 container.RunContainerExecProcess()
+// 3.父进程把 exec 进程加入容器的 cgroup，再通过管道把命令、环境变量、工作目录、用户等配置发送给子进程
+// exec 进程的 capability 以容器的为基础，--privileged 时以全部 capability 为基础
+// 返回值为 exec 命令的退出码，出错时返回 1
+func ExecContainer(containerName string, commandArray []string, tty, detach bool, envSlice []string, workDir, user string,
+	capAdd, capDrop []string, privileged bool) int {
 	pid, err := GetContainerPidByName(containerName)
 	if err != nil {
 		logrus.Errorf("Exec container getContainerPidByName %s error %v", containerName, err)
-		return
+		return 1
 	}
 
 	cmdStr := strings.Join(commandArray, " ")
 	logrus.Infof("ExecContainer: container pid %s", pid)
 	logrus.Infof("ExecContainer: command [%s]", cmdStr)
 
+	readPipe, writePipe, err := container.NewPipe()
+	if err != nil {
+		logrus.Errorf("New pipe error %v", err)
+		return 1
+	}
+	defer writePipe.Close()
+
+	// 默认使用容器 init 进程的环境变量，-e 指定的环境变量追加在后面
+	containerEnvs := getEnvsByPid(pid)
+	config := &container.ProcessConfig{
+		Args: commandArray,
+		Env:  append(containerEnvs, envSlice...),
+		Cwd:  workDir,
+		User: user,
+		Tty:  tty,
+	}
+
+	containerInfo, err := getContainerInfoByName(containerName)
+	if err != nil {
+		logrus.Errorf("Exec container get container info %s error %v", containerName, err)
+		return 1
+	}
+	baseCaps := containerInfo.Capabilities
+	if privileged {
+		baseCaps = container.AllCapabilities()
+	} else if baseCaps == nil {
+		// 没有记录 capability 的老容器使用默认值
+		baseCaps = container.DefaultCapabilities
+	}
+	if config.Capabilities, err = container.ResolveCapabilities(baseCaps, capAdd, capDrop); err != nil {
+		logrus.Errorf("Exec container resolve capabilities error %v", err)
+		return 1
+	}
+	// 非 root 用户只获得容器和 exec 的 --cap-add 指定的 capability
+	config.AmbientCaps = container.AmbientCapabilities(config.Capabilities, append(containerInfo.CapAdd, capAdd...))
+
+	if containerInfo.Resources != nil {
+		config.Ulimits = containerInfo.Resources.Ulimits
+	}
+
+	if config.Seccomp, err = container.LoadSeccompProfile(containerName); err != nil {
+		logrus.Errorf("Exec container load seccomp profile error %v", err)
+		return 1
+	}
+	if config.Landlock, err = container.LoadLandlockPolicy(containerName); err != nil {
+		logrus.Errorf("Exec container load landlock policy error %v", err)
+		return 1
+	}
+
 	cmd := exec.Command("/proc/self/exe", "exec")
-	cmd.Stdin = os.Stdin
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
+	cmd.Env = append(config.Env, ENV_EXEC_PID+"="+pid)
+	// 容器有自己的 user namespace 时，exec 进程也需要进入，否则 exec 进程的 capability 在宿主机上仍然生效
+	// 进入之后宿主机的用户在容器内不一定有映射，默认切换为容器内的 root
+	if containerInfo.Userns != nil && !containerInfo.Userns.Host {
+		cmd.Env = append(cmd.Env, ENV_EXEC_JOIN_USERNS+"=1")
+		if config.User == "" {
+			config.User = "0"
+		}
+	}
+	cmd.ExtraFiles = []*os.File{readPipe}
 
-	os.Setenv(ENV_EXEC_PID, pid)
-	os.Setenv(ENV_EXEC_CMD, cmdStr)
-	//containerEnvs := getEnvsByPid(pid)
-	//cmd.Env = append(os.Environ(), containerEnvs...)
+	execID := generateRandomID(10)
+	var master, slave *os.File
+	if tty {
+		master, slave, err = container.NewPty()
+		if err != nil {
+			logrus.Errorf("Exec container new pty error %v", err)
+			return 1
+		}
+		defer master.Close()
+		cmd.Stdin = slave
+		cmd.Stdout = slave
+		cmd.Stderr = slave
+	} else if detach {
+		// 后台运行的 exec 会话的输出保存在 exec/会话Id.log 中
+		logFile, err := createExecLogFile(containerName, execID)
+		if err != nil {
+			logrus.Errorf("Exec container create log file error %v", err)
+			return 1
+		}
+		defer logFile.Close()
+		cmd.Stdout = logFile
+		cmd.Stderr = logFile
+	} else {
+		cmd.Stdin = os.Stdin
+		cmd.Stdout = os.Stdout
+		cmd.Stderr = os.Stderr
+	}
+	if detach {
+		// 脱离当前终端所在的会话，避免终端关闭时会话被 SIGHUP 杀死
+		cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+	}
 
-	if err := cmd.Run(); err != nil {
+	if err := cmd.Start(); err != nil {
 		logrus.Errorf("Exec container %s error %v", containerName, err)
+		return 1
 	}
+	readPipe.Close()
+	if slave != nil {
+		// 父进程持有的 slave 端必须关闭，否则进程退出后 master 端永远读不到结束
+		slave.Close()
+	}
+
+	execInfo := &container.ExecInfo{
+		Id:          execID,
+		Pid:         fmt.Sprintf("%d", cmd.Process.Pid),
+		Command:     cmdStr,
+		User:        user,
+		Cwd:         workDir,
+		Tty:         tty,
+		Detach:      detach,
+		CreatedTime: time.Now().Format("2006-01-02 15:04:05"),
+		Status:      container.RUNNING,
+	}
+	if execInfo.StartTime, err = container.ProcessStartTime(execInfo.Pid); err != nil {
+		logrus.Warnf("Get exec process start time error %v", err)
+	}
+	if err := container.RecordExecInfo(containerName, execInfo); err != nil {
+		logrus.Errorf("Record exec info error %v", err)
+	}
+
+	// exec 进程与容器共用一个 cgroup，受到相同的资源限制，nsenter 在 fork 之前等待这里的通知
+	_ = cgroup.NewCgroupManager(containerCgroupPath(containerInfo)).Apply(cmd.Process.Pid)
+	if _, err := writePipe.Write([]byte{0}); err != nil {
+		logrus.Errorf("Notify exec process error %v", err)
+		return 1
+	}
+	if err := sendProcessConfig(config, writePipe); err != nil {
+		logrus.Errorf("Send exec config error %v", err)
+		return 1
+	}
+
+	if detach {
+		fmt.Println(execID)
+		return 0
+	}
+	defer container.RemoveExecInfo(containerName, execID)
+
+	var terminal *hostTerminal
+	if tty {
+		// exec 的 -it 总是同时分配终端并转发标准输入
+		terminal = attachHostTerminal(&container.ProcessIO{Console: master, Stdin: master}, os.Stdout, os.Stderr)
+	}
+	exitCode := containerExitCode(cmd.Wait())
+	if terminal != nil {
+		terminal.wait()
+	}
+	return exitCode
+}
+
+func sendProcessConfig(config *container.ProcessConfig, writePipe *os.File) error {
+	bytes, err := json.Marshal(config)
+	if err != nil {
+		return err
+	}
+	if _, err := writePipe.Write(bytes); err != nil {
+		return err
+	}
+	return writePipe.Close()
+}
+
+func createExecLogFile(containerName, execID string) (*os.File, error) {
+	dirURL := path.Join(fmt.Sprintf(container.DefaultInfoLocation, containerName), container.ExecDirName)
+	if err := os.MkdirAll(dirURL, 0700); err != nil {
+		return nil, err
+	}
+	return os.Create(path.Join(dirURL, execID+".log"))
 }
 
 // 基本逻辑：
@@ -65,6 +232,11 @@ func getEnvsByPid(pid string) []string {
 		return nil
 	}
 	//env split by \u0000
-	envs := strings.Split(string(contentBytes), "\u0000")
+	var envs []string
+	for _, env := range strings.Split(string(contentBytes), "\u0000") {
+		if env != "" {
+			envs = append(envs, env)
+		}
+	}
 	return envs
-}
\ No newline at end of file
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/Sirupsen/logrus"
+	"github.com/kkBill/mydocker/container"
+)
+
+// inspect 输出的内容：容器信息以及正在该容器中执行的 exec 会话
+type inspectInfo struct {
+	*container.ContainerInfo
+	Execs []*container.ExecInfo `json:"execs"`
+}
+
+func inspectContainer(containerName string) {
+	containerInfo, err := getContainerInfoByName(containerName)
+	if err != nil {
+		logrus.Errorf("Get container %s info error %v", containerName, err)
+		return
+	}
+	execs, err := container.ListExecInfos(containerName)
+	if err != nil {
+		logrus.Errorf("List exec sessions of container %s error %v", containerName, err)
+	}
+	bytes, err := json.MarshalIndent(&inspectInfo{containerInfo, execs}, "", "    ")
+	if err != nil {
+		logrus.Errorf("Json marshal %s error %v", containerName, err)
+		return
+	}
+	fmt.Println(string(bytes))
+}
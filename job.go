@@ -0,0 +1,121 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/kkBill/mydocker/cgroup"
+	"github.com/kkBill/mydocker/container"
+)
+
+// --timeout 到期后先发送 SIGTERM，经过宽限期容器仍未退出时发送 SIGKILL
+const timeoutGracePeriod = 10 * time.Second
+
+// 监视容器是否超过 --timeout 指定的运行时间
+type timeoutWatcher struct {
+	mu       sync.Mutex
+	done     chan struct{}
+	stopped  bool //容器已经退出，不再发送信号
+	timedOut bool //超时后已经向容器发送了 SIGTERM
+}
+
+// timeout 为 0 时不限制运行时间
+func watchTimeout(process *os.Process, timeout time.Duration) *timeoutWatcher {
+	w := &timeoutWatcher{done: make(chan struct{})}
+	if timeout <= 0 {
+		return w
+	}
+	go func() {
+		select {
+		case <-w.done:
+			return
+		case <-time.After(timeout):
+		}
+		// 与 stop 互斥：容器已经退出时不发送信号，只有 SIGTERM 确实发出去了才算超时
+		w.mu.Lock()
+		if w.stopped || process.Signal(syscall.SIGTERM) != nil {
+			w.mu.Unlock()
+			return
+		}
+		w.timedOut = true
+		w.mu.Unlock()
+		logrus.Warnf("container exceeded timeout %v, stopping it", timeout)
+		select {
+		case <-w.done:
+		case <-time.After(timeoutGracePeriod):
+			logrus.Warnf("container did not stop in %v, killing it", timeoutGracePeriod)
+			process.Kill()
+		}
+	}()
+	return w
+}
+
+// 容器退出后调用，返回容器是否是因为超时被停止的
+func (w *timeoutWatcher) stop() bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if !w.stopped {
+		w.stopped = true
+		close(w.done)
+	}
+	return w.timedOut
+}
+
+// 容器退出后记录退出码和退出原因，--report 时从 cgroup 中统计资源使用情况，打印并保存到容器信息中
+// 后台运行的容器由 monitor 进程打印，汇总写到 monitor.log 中，用户通过 inspect 查看保存的汇总
+// stop 命令已经修改过状态的话，这里就不再修改状态
+func recordContainerExit(containerInfo *container.ContainerInfo, cgroupManager *cgroup.CgroupManager, exitCode int, timedOut bool, startTime time.Time) {
+	var report *container.Report
+	err := modifyContainerInfo(containerInfo.Name, func(info *container.ContainerInfo) {
+		if info.Status == container.RUNNING {
+			info.Status = container.Exit
+		}
+		info.Pid = "--"
+		info.ExitCode = exitCode
+		if timedOut {
+			info.ExitReason = container.ExitReasonTimeout
+		}
+		if !info.Report {
+			return
+		}
+		report = &container.Report{
+			WallTime:   time.Since(startTime),
+			ExitCode:   exitCode,
+			ExitReason: info.ExitReason,
+		}
+		if stats, err := cgroupManager.Stats(); err != nil {
+			logrus.Warnf("read container %s resource usage error %v", info.Name, err)
+		} else {
+			report.CpuUser = stats.CpuUser
+			report.CpuSystem = stats.CpuSystem
+			report.MemoryMaxUsage = stats.MemoryMaxUsage
+			report.OomKills = stats.OomKills
+		}
+		info.Summary = report
+	})
+	if err != nil {
+		logrus.Errorf("update container %s info error %v", containerInfo.Name, err)
+	}
+	if report != nil {
+		printReport(os.Stderr, containerInfo.Name, report)
+	}
+}
+
+func printReport(w io.Writer, containerName string, report *container.Report) {
+	exit := fmt.Sprintf("%d", report.ExitCode)
+	if report.ExitReason != "" {
+		exit += " (" + report.ExitReason + ")"
+	}
+	fmt.Fprintf(w, "container %s report:\n", containerName)
+	fmt.Fprintf(w, "  wall time:    %v\n", report.WallTime.Round(time.Millisecond))
+	fmt.Fprintf(w, "  cpu user:     %v\n", report.CpuUser)
+	fmt.Fprintf(w, "  cpu system:   %v\n", report.CpuSystem)
+	fmt.Fprintf(w, "  memory peak:  %.1f MiB\n", float64(report.MemoryMaxUsage)/(1<<20))
+	fmt.Fprintf(w, "  oom kills:    %d\n", report.OomKills)
+	fmt.Fprintf(w, "  exit code:    %s\n", exit)
+}
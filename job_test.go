@@ -0,0 +1,42 @@
+package main
+
+import (
+	"os/exec"
+	"testing"
+	"time"
+)
+
+func TestTimeoutWatcher(t *testing.T) {
+	// 超时后 SIGTERM 发送成功，算作超时
+	cmd := exec.Command("sleep", "10")
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	w := watchTimeout(cmd.Process, 50*time.Millisecond)
+	cmd.Wait()
+	if !w.stop() {
+		t.Errorf("SIGTERM delivered: expected timedOut")
+	}
+
+	// 超时的时候进程已经退出并被回收，SIGTERM 发送失败，不算超时
+	cmd = exec.Command("true")
+	if err := cmd.Run(); err != nil {
+		t.Fatal(err)
+	}
+	w = watchTimeout(cmd.Process, 10*time.Millisecond)
+	time.Sleep(100 * time.Millisecond)
+	if w.stop() {
+		t.Errorf("process already gone: expected not timedOut")
+	}
+
+	// 超时之前容器已经退出
+	cmd = exec.Command("sleep", "10")
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer cmd.Process.Kill()
+	w = watchTimeout(cmd.Process, time.Hour)
+	if w.stop() {
+		t.Errorf("stopped before timeout: expected not timedOut")
+	}
+}
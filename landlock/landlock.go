@@ -0,0 +1,172 @@
+package landlock
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+	"unsafe"
+
+	"github.com/Sirupsen/logrus"
+)
+
+// landlock 的系统调用号在所有架构上都相同
+const (
+	sysLandlockCreateRuleset = 444
+	sysLandlockAddRule       = 445
+	sysLandlockRestrictSelf  = 446
+
+	createRulesetVersion = 1
+	rulePathBeneath      = 1
+	prSetNoNewPrivs      = 38
+	oPath                = 0x200000
+)
+
+// 文件系统访问权限，见 linux/landlock.h
+const (
+	accessExecute    = 1 << 0
+	accessWriteFile  = 1 << 1
+	accessReadFile   = 1 << 2
+	accessReadDir    = 1 << 3
+	accessRemoveDir  = 1 << 4
+	accessRemoveFile = 1 << 5
+	accessMakeChar   = 1 << 6
+	accessMakeDir    = 1 << 7
+	accessMakeReg    = 1 << 8
+	accessMakeSock   = 1 << 9
+	accessMakeFifo   = 1 << 10
+	accessMakeBlock  = 1 << 11
+	accessMakeSym    = 1 << 12
+	accessRefer      = 1 << 13 // ABI 2
+	accessTruncate   = 1 << 14 // ABI 3
+	accessIoctlDev   = 1 << 15 // ABI 5
+)
+
+// 可以作用于普通文件的权限，其余的权限只能用于目录
+const accessFile = accessExecute | accessWriteFile | accessReadFile | accessTruncate | accessIoctlDev
+
+// 各个 ABI 版本支持的全部文件系统权限，下标为版本号
+var abiAccess = []uint64{
+	0,
+	accessMakeSym<<1 - 1,
+	accessRefer<<1 - 1,
+	accessTruncate<<1 - 1,
+	accessTruncate<<1 - 1,
+	accessIoctlDev<<1 - 1,
+}
+
+// 配置文件中的访问类型对应的权限
+var accessMap = map[Access]uint64{
+	AccessRead: accessReadFile | accessReadDir,
+	AccessWrite: accessWriteFile | accessRemoveDir | accessRemoveFile | accessMakeChar | accessMakeDir | accessMakeReg |
+		accessMakeSock | accessMakeFifo | accessMakeBlock | accessMakeSym | accessRefer | accessTruncate | accessIoctlDev,
+	AccessExec: accessExecute,
+}
+
+func accessRights(access []Access) (uint64, error) {
+	var rights uint64
+	for _, a := range access {
+		r, ok := accessMap[a]
+		if !ok {
+			return 0, fmt.Errorf("unknown access %q, must be read, write or exec", a)
+		}
+		rights |= r
+	}
+	return rights, nil
+}
+
+// 返回内核支持的 landlock ABI 版本，内核没有编译 landlock 或启动时没有启用时返回错误
+func ABIVersion() (int, error) {
+	version, _, errno := syscall.RawSyscall(sysLandlockCreateRuleset, 0, 0, createRulesetVersion)
+	switch errno {
+	case 0:
+		return int(version), nil
+	case syscall.ENOSYS:
+		return 0, fmt.Errorf("landlock is not supported by the running kernel")
+	case syscall.EOPNOTSUPP:
+		return 0, fmt.Errorf("landlock is supported but not enabled by the running kernel, add landlock to the lsm= boot parameter")
+	}
+	return 0, fmt.Errorf("get landlock abi version error %v", errno)
+}
+
+// 在当前线程上应用 landlock 规则，之后 exec 的进程也受到同样的限制，policy 为 nil 时不做限制：
+// 1.根据内核的 ABI 版本确定能够限制的权限，较老的内核上新增的权限不受限制
+// 2.为配置文件中的每个路径添加规则，不存在的路径跳过
+// 3.设置 no_new_privs 后限制当前线程
+// 调用方需要先 runtime.LockOSThread()，并且在挂载完成之后调用，受限的进程不能再修改挂载点
+func Restrict(policy *Policy) error {
+	if policy == nil {
+		return nil
+	}
+	version, err := ABIVersion()
+	if err != nil {
+		return err
+	}
+	if version >= len(abiAccess) {
+		version = len(abiAccess) - 1
+	}
+	handled := abiAccess[version]
+	logrus.Infof("landlock abi version %d, handled access %#x", version, handled)
+
+	rulesetAttr := handled
+	fd, _, errno := syscall.RawSyscall(sysLandlockCreateRuleset, uintptr(unsafe.Pointer(&rulesetAttr)), unsafe.Sizeof(rulesetAttr), 0)
+	if errno != 0 {
+		return fmt.Errorf("create landlock ruleset error %v", errno)
+	}
+	rulesetFd := int(fd)
+	defer syscall.Close(rulesetFd)
+
+	for _, rule := range policy.Rules {
+		rights, err := accessRights(rule.Access)
+		if err != nil {
+			return err
+		}
+		for _, p := range rule.Paths {
+			if err := addPathRule(rulesetFd, p, rights&handled); err != nil {
+				return err
+			}
+		}
+	}
+
+	if _, _, errno := syscall.RawSyscall6(syscall.SYS_PRCTL, prSetNoNewPrivs, 1, 0, 0, 0, 0); errno != 0 {
+		return fmt.Errorf("set no_new_privs error %v", errno)
+	}
+	if _, _, errno := syscall.RawSyscall(sysLandlockRestrictSelf, uintptr(rulesetFd), 0, 0); errno != 0 {
+		return fmt.Errorf("landlock restrict self error %v", errno)
+	}
+	return nil
+}
+
+// struct landlock_path_beneath_attr 是 packed 的，一共 12 个字节
+type pathBeneathAttr struct {
+	allowedAccess [8]byte
+	parentFd      int32
+}
+
+func addPathRule(rulesetFd int, p string, rights uint64) error {
+	fd, err := syscall.Open(p, oPath|syscall.O_CLOEXEC, 0)
+	if err != nil {
+		if os.IsNotExist(err) {
+			logrus.Warnf("landlock path %s does not exist, skip it", p)
+			return nil
+		}
+		return fmt.Errorf("open landlock path %s error %v", p, err)
+	}
+	defer syscall.Close(fd)
+
+	var st syscall.Stat_t
+	if err := syscall.Fstat(fd, &st); err != nil {
+		return fmt.Errorf("stat landlock path %s error %v", p, err)
+	}
+	if st.Mode&syscall.S_IFMT != syscall.S_IFDIR {
+		rights &= accessFile
+	}
+	if rights == 0 {
+		return nil
+	}
+	attr := pathBeneathAttr{parentFd: int32(fd)}
+	*(*uint64)(unsafe.Pointer(&attr.allowedAccess[0])) = rights
+	if _, _, errno := syscall.RawSyscall6(sysLandlockAddRule, uintptr(rulesetFd), rulePathBeneath, uintptr(unsafe.Pointer(&attr)), 0, 0, 0); errno != 0 {
+		return fmt.Errorf("add landlock rule for %s error %v", p, errno)
+	}
+	return nil
+}
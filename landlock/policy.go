@@ -0,0 +1,63 @@
+package landlock
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+)
+
+// --security-opt landlock= 指定的配置文件，格式为：
+// {"rules": [{"paths": ["/bin", "/lib"], "access": ["read", "exec"]}, {"paths": ["/tmp"], "access": ["read", "write"]}]}
+// 没有列出的路径既不能读写也不能执行
+type Policy struct {
+	Rules []*Rule `json:"rules"`
+}
+
+// 一组路径及允许的访问，目录的规则对其下所有文件生效
+type Rule struct {
+	Paths  []string `json:"paths"`
+	Access []Access `json:"access"`
+}
+
+type Access string
+
+const (
+	AccessRead  Access = "read"  //读文件、列目录
+	AccessWrite Access = "write" //写文件，创建、删除、重命名目录项
+	AccessExec  Access = "exec"  //执行文件
+)
+
+// 读取并检查配置文件
+func LoadPolicy(file string) (*Policy, error) {
+	bytes, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("read landlock policy %s error %v", file, err)
+	}
+	policy, err := ParsePolicy(bytes)
+	if err != nil {
+		return nil, fmt.Errorf("invalid landlock policy %s: %v", file, err)
+	}
+	return policy, nil
+}
+
+func ParsePolicy(bytes []byte) (*Policy, error) {
+	var policy Policy
+	if err := json.Unmarshal(bytes, &policy); err != nil {
+		return nil, err
+	}
+	for _, rule := range policy.Rules {
+		if len(rule.Paths) == 0 {
+			return nil, fmt.Errorf("rule has no paths")
+		}
+		for _, p := range rule.Paths {
+			if !filepath.IsAbs(p) {
+				return nil, fmt.Errorf("path %q is not absolute", p)
+			}
+		}
+		if _, err := accessRights(rule.Access); err != nil {
+			return nil, err
+		}
+	}
+	return &policy, nil
+}
@@ -0,0 +1,43 @@
+package landlock
+
+import "testing"
+
+func TestParsePolicy(t *testing.T) {
+	policy, err := ParsePolicy([]byte(`{"rules": [{"paths": ["/bin"], "access": ["read", "exec"]}, {"paths": ["/tmp"], "access": ["write"]}]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(policy.Rules) != 2 {
+		t.Fatalf("got %d rules, want 2", len(policy.Rules))
+	}
+	rights, err := accessRights(policy.Rules[0].Access)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rights != accessReadFile|accessReadDir|accessExecute {
+		t.Errorf("read and exec rights: got %#x", rights)
+	}
+
+	for _, p := range []string{
+		`{"rules": [{"paths": [], "access": ["read"]}]}`,
+		`{"rules": [{"paths": ["bin"], "access": ["read"]}]}`,
+		`{"rules": [{"paths": ["/bin"], "access": ["rwx"]}]}`,
+		`{"rules": 1}`,
+	} {
+		if _, err := ParsePolicy([]byte(p)); err == nil {
+			t.Errorf("ParsePolicy(%s) expected error", p)
+		}
+	}
+}
+
+func TestABIAccess(t *testing.T) {
+	// 每个版本的权限都包含上一个版本的权限
+	for v := 2; v < len(abiAccess); v++ {
+		if abiAccess[v]&abiAccess[v-1] != abiAccess[v-1] {
+			t.Errorf("abi %d access %#x does not include abi %d access %#x", v, abiAccess[v], v-1, abiAccess[v-1])
+		}
+	}
+	if abiAccess[1] != 0x1fff {
+		t.Errorf("abi 1 access: got %#x, want 0x1fff", abiAccess[1])
+	}
+}
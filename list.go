@@ -21,7 +21,7 @@ func ListContainers()  {
 
 	var containers []*container.ContainerInfo
 	for _, file := range files {
-		if file.Name() == "network" {
+		if file.Name() == "network" || file.Name() == container.PodDirName {
 			continue
 		}
 		tmpContainer, err := getContainerInfo(file)
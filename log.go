@@ -4,26 +4,73 @@ import (
 	"fmt"
 	"github.com/Sirupsen/logrus"
 	"github.com/kkBill/mydocker/container"
-	"io/ioutil"
+	"github.com/kkBill/mydocker/logger"
+	"io"
 	"os"
+	"strings"
+	"time"
 )
 
-func logContainer(containerName string)  {
-	dirURL := fmt.Sprintf(container.DefaultInfoLocation, containerName)
-	logFileLocation := dirURL + container.ContainerLogFile
-	file, err := os.Open(logFileLocation)
-	//noinspection GoNilness
-	defer file.Close()
+// 解析 --log-opt key=value 形式的日志选项，并检查日志驱动和选项是否合法
+func parseLogOpts(logDriver string, logOpts []string) (map[string]string, error) {
+	opts := map[string]string{}
+	for _, opt := range logOpts {
+		kv := strings.SplitN(opt, "=", 2)
+		if len(kv) != 2 || kv[0] == "" {
+			return nil, fmt.Errorf("invalid log opt %q, must be key=value", opt)
+		}
+		opts[kv[0]] = kv[1]
+	}
+	if err := logger.ValidateDriver(logDriver, opts); err != nil {
+		return nil, err
+	}
+	return opts, nil
+}
+
+// 按照容器的配置创建日志驱动
+func newLogDriver(containerInfo *container.ContainerInfo) (logger.LogDriver, error) {
+	dirURL := fmt.Sprintf(container.DefaultInfoLocation, containerInfo.Name)
+	return logger.NewDriver(containerInfo.LogDriver, &logger.DriverContext{
+		ContainerID:   containerInfo.Id,
+		ContainerName: containerInfo.Name,
+		LogPath:       dirURL + container.ContainerLogFile,
+		Opts:          containerInfo.LogOpts,
+	})
+}
+
+// 读取容器的 json 日志并输出，标准错误的日志输出到标准错误上
+func logContainer(containerName string, config *logger.ReadConfig, timestamps bool) {
+	containerInfo, err := getContainerInfoByName(containerName)
 	if err != nil {
-		logrus.Errorf("logContainer: Log container open file %s error %v", logFileLocation, err)
+		logrus.Errorf("Get container %s info error %v", containerName, err)
 		return
 	}
-	content, err := ioutil.ReadAll(file)
+	if !logger.IsReadable(containerInfo.LogDriver) {
+		logrus.Errorf("Container %s uses log driver %s, which does not support reading logs; only %s logs can be read",
+			containerName, containerInfo.LogDriver, logger.JSONFileDriver)
+		return
+	}
+	dirURL := fmt.Sprintf(container.DefaultInfoLocation, containerName)
+	logFileLocation := dirURL + container.ContainerLogFile
+	// follow 模式下，容器不再运行时停止等待新的日志
+	config.Done = func() bool {
+		containerInfo, err := getContainerInfoByName(containerName)
+		return err != nil || containerInfo.Status != container.RUNNING
+	}
+	err = logger.ReadJSONLog(logFileLocation, config, func(msg *logger.Message) error {
+		var out io.Writer = os.Stdout
+		if msg.Stream == logger.Stderr {
+			out = os.Stderr
+		}
+		if timestamps {
+			if _, err := fmt.Fprintf(out, "%s ", msg.Time.Format(time.RFC3339Nano)); err != nil {
+				return err
+			}
+		}
+		_, err := fmt.Fprint(out, msg.Log)
+		return err
+	})
 	if err != nil {
 		logrus.Errorf("logContainer: Log container read file %s error %v", logFileLocation, err)
-		return
 	}
-	// 通过Fprint()把日志文件内容输出到标准控制台上
-	//noinspection GoUnhandledErrorResult
-	fmt.Fprint(os.Stdout, string(content))
-}
\ No newline at end of file
+}
@@ -0,0 +1,113 @@
+package logger
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sync"
+)
+
+// 日志驱动的名字
+const (
+	JSONFileDriver = "json-file"
+	SyslogDriver   = "syslog"
+	NoneDriver     = "none"
+)
+
+// 默认的日志驱动
+const DefaultDriver = JSONFileDriver
+
+// 日志驱动负责保存容器的输出，由持有容器标准输入输出的进程（monitor 进程）创建
+type LogDriver interface {
+	// 返回某个输出流对应的 io.Writer，写入的内容按行切分成日志
+	StreamWriter(stream string) io.Writer
+	// 写完各个输出流中剩余的内容，然后释放资源
+	Close() error
+}
+
+// 创建日志驱动需要的容器信息
+type DriverContext struct {
+	ContainerID   string
+	ContainerName string
+	LogPath       string            //json-file 驱动的日志文件路径
+	Opts          map[string]string //--log-opt 指定的选项
+}
+
+// 根据名字创建日志驱动，名字为空时使用默认的驱动
+func NewDriver(driver string, ctx *DriverContext) (LogDriver, error) {
+	switch driverName(driver) {
+	case JSONFileDriver:
+		return NewJSONFileWriter(ctx.LogPath, ctx.Opts)
+	case SyslogDriver:
+		return NewSyslogWriter(ctx)
+	case NoneDriver:
+		if len(ctx.Opts) > 0 {
+			return nil, fmt.Errorf("log driver %s does not support log opts", NoneDriver)
+		}
+		return noneDriver{}, nil
+	}
+	return nil, fmt.Errorf("unknown log driver %q", driver)
+}
+
+// 检查日志驱动及其选项是否合法，run 命令在启动容器之前调用
+func ValidateDriver(driver string, opts map[string]string) error {
+	switch driverName(driver) {
+	case JSONFileDriver:
+		return ValidateJSONFileOptions(opts)
+	case SyslogDriver:
+		_, err := parseSyslogConfig(opts)
+		return err
+	case NoneDriver:
+		if len(opts) > 0 {
+			return fmt.Errorf("log driver %s does not support log opts", NoneDriver)
+		}
+		return nil
+	}
+	return fmt.Errorf("unknown log driver %q", driver)
+}
+
+// 只有 json-file 驱动的日志保存在本地，可以被 logs 命令读取
+func IsReadable(driver string) bool {
+	return driverName(driver) == JSONFileDriver
+}
+
+func driverName(driver string) string {
+	if driver == "" {
+		return DefaultDriver
+	}
+	return driver
+}
+
+// none 驱动丢弃容器的所有输出
+type noneDriver struct{}
+
+func (noneDriver) StreamWriter(stream string) io.Writer {
+	return ioutil.Discard
+}
+
+func (noneDriver) Close() error {
+	return nil
+}
+
+// 各个日志驱动共用的输出流管理：按行切分输出流，关闭时写完剩余的半行
+type streamSet struct {
+	mu      sync.Mutex
+	streams []*streamWriter
+}
+
+func (s *streamSet) newStream(stream string, sink messageWriter) io.Writer {
+	sw := &streamWriter{stream: stream, writer: sink}
+	s.mu.Lock()
+	s.streams = append(s.streams, sw)
+	s.mu.Unlock()
+	return sw
+}
+
+func (s *streamSet) flush() {
+	s.mu.Lock()
+	streams := s.streams
+	s.mu.Unlock()
+	for _, sw := range streams {
+		sw.flush()
+	}
+}
@@ -0,0 +1,375 @@
+package logger
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// 容器输出流的名字
+const (
+	Stdout = "stdout"
+	Stderr = "stderr"
+)
+
+// 单行日志的最大长度，超过之后即使没有遇到换行也会写成一条日志
+const maxLineSize = 16 * 1024
+
+// json 日志文件中的一行，格式为 {"stream":"stderr","time":"...","log":"..."}
+type Message struct {
+	Stream string    `json:"stream"` //输出流，stdout 或 stderr
+	Time   time.Time `json:"time"`   //产生日志的时间
+	Log    string    `json:"log"`    //日志内容，包含行尾的换行符
+}
+
+// json-file 日志驱动，以 json-lines 的格式把容器的输出写入日志文件
+// 设置了 max-size 时，文件超过大小后会轮转成 path.1、path.2 ...，最多保留 max-file 个文件
+type JSONFileWriter struct {
+	mu      sync.Mutex
+	path    string
+	file    *os.File
+	size    int64
+	rotate  *rotateConfig
+	streams streamSet
+	// 后台压缩轮转出来的文件，完成时返回压缩的结果，没有在压缩时为 nil
+	compressDone chan error
+}
+
+// opts 是 --log-opt 指定的选项，支持 max-size、max-file 和 compress
+func NewJSONFileWriter(path string, opts map[string]string) (*JSONFileWriter, error) {
+	config, err := parseRotateConfig(opts)
+	if err != nil {
+		return nil, err
+	}
+	w := &JSONFileWriter{path: path, rotate: config}
+	if err := w.openFile(); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+func (w *JSONFileWriter) openFile() error {
+	file, err := os.OpenFile(w.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	stat, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	w.file = file
+	w.size = stat.Size()
+	return nil
+}
+
+// 写入一条日志
+func (w *JSONFileWriter) WriteMessage(msg *Message) error {
+	line, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	// 写入这一行会超过大小限制时先轮转，一行日志不会被拆到两个文件中
+	if w.rotate.maxSize > 0 && w.size > 0 && w.size+int64(len(line)) > w.rotate.maxSize {
+		w.file.Close()
+		// 上一次的压缩完成之后才能移动轮转出来的文件
+		compressErr := w.waitCompress()
+		// 轮转失败时继续写原来的文件，不丢日志
+		compressPath, rotateErr := rotate(w.path, w.rotate)
+		if compressPath != "" {
+			// 压缩可能比较慢，放到后台进行，不能阻塞容器的输出
+			done := make(chan error, 1)
+			w.compressDone = done
+			dst := rotatedPath(w.path, 1, true)
+			go func() {
+				done <- compressRotated(compressPath, dst)
+			}()
+		}
+		if err := w.openFile(); err != nil {
+			return err
+		}
+		if rotateErr != nil {
+			err = fmt.Errorf("rotate %s error %v", w.path, rotateErr)
+		} else if compressErr != nil {
+			err = fmt.Errorf("compress rotated %s error %v", w.path, compressErr)
+		}
+	}
+	n, writeErr := w.file.Write(line)
+	w.size += int64(n)
+	if writeErr != nil {
+		return writeErr
+	}
+	return err
+}
+
+func (w *JSONFileWriter) StreamWriter(stream string) io.Writer {
+	return w.streams.newStream(stream, w)
+}
+
+// 等待后台的压缩完成，调用者需要持有 w.mu
+func (w *JSONFileWriter) waitCompress() error {
+	if w.compressDone == nil {
+		return nil
+	}
+	err := <-w.compressDone
+	w.compressDone = nil
+	return err
+}
+
+// 把各个输出流中还没有换行的内容写入日志，等待后台的压缩完成，然后关闭日志文件
+func (w *JSONFileWriter) Close() error {
+	w.streams.flush()
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	compressErr := w.waitCompress()
+	if err := w.file.Close(); err != nil {
+		return err
+	}
+	return compressErr
+}
+
+// 接收切分好的一条条日志
+type messageWriter interface {
+	WriteMessage(msg *Message) error
+}
+
+// 把一个输出流的内容按行切分，每行写成一条日志
+type streamWriter struct {
+	mu     sync.Mutex
+	stream string
+	writer messageWriter
+	buf    []byte
+}
+
+func (sw *streamWriter) Write(p []byte) (int, error) {
+	sw.mu.Lock()
+	defer sw.mu.Unlock()
+	sw.buf = append(sw.buf, p...)
+	for {
+		i := bytes.IndexByte(sw.buf, '\n')
+		if i < 0 {
+			if len(sw.buf) < maxLineSize {
+				break
+			}
+			i = maxLineSize - 1
+		}
+		// 写日志失败时丢弃这一行，不能因为日志而阻塞容器的输出
+		sw.writeLine(sw.buf[:i+1])
+		sw.buf = sw.buf[i+1:]
+	}
+	return len(p), nil
+}
+
+func (sw *streamWriter) flush() {
+	sw.mu.Lock()
+	defer sw.mu.Unlock()
+	if len(sw.buf) > 0 {
+		sw.writeLine(sw.buf)
+		sw.buf = nil
+	}
+}
+
+func (sw *streamWriter) writeLine(line []byte) error {
+	return sw.writer.WriteMessage(&Message{
+		Stream: sw.stream,
+		Time:   time.Now().UTC(),
+		Log:    string(line),
+	})
+}
+
+// 读取日志时的过滤条件
+type ReadConfig struct {
+	Tail   int       //只输出最后 Tail 行，小于 0 表示全部输出
+	Since  time.Time //只输出这个时间之后的日志，零值表示不限制
+	Until  time.Time //只输出这个时间之前的日志，零值表示不限制
+	Stdout bool      //是否输出标准输出的日志
+	Stderr bool      //是否输出标准错误的日志
+	Follow bool      //输出完已有的日志后，是否继续等待新的日志
+	// Follow 模式下，读到文件末尾时调用，返回 true 表示不再等待新的日志（比如容器已经退出）
+	Done func() bool
+}
+
+func (config *ReadConfig) match(msg *Message) bool {
+	if msg.Stream == Stdout && !config.Stdout || msg.Stream == Stderr && !config.Stderr {
+		return false
+	}
+	if !config.Since.IsZero() && msg.Time.Before(config.Since) {
+		return false
+	}
+	if !config.Until.IsZero() && msg.Time.After(config.Until) {
+		return false
+	}
+	return true
+}
+
+// follow 模式下检查日志文件是否有新内容的时间间隔
+var followInterval = 200 * time.Millisecond
+
+// follow 模式下读到文件末尾之后、检查文件是否轮转之前调用，测试用来在这个时机写入日志
+var followEOFHook = func() {}
+
+// 按照过滤条件读取 json 日志文件，每条日志调用一次 handle
+// 先按从旧到新的顺序读取轮转出来的文件，再读取正在写入的文件
+func ReadJSONLog(path string, config *ReadConfig, handle func(*Message) error) error {
+	// 先读出所有已有的日志，按照 Tail 只保留最后几条
+	var messages []*Message
+	keep := func(msg *Message) error {
+		if !config.match(msg) {
+			return nil
+		}
+		messages = append(messages, msg)
+		if config.Tail >= 0 && len(messages) > config.Tail {
+			messages = messages[1:]
+		}
+		return nil
+	}
+	for _, rotated := range rotatedFiles(path) {
+		if err := readLogFile(rotated, keep); err != nil {
+			return err
+		}
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer func() { file.Close() }()
+	reader := bufio.NewReader(file)
+	partial, err := readMessages(reader, nil, keep)
+	if err != nil {
+		return err
+	}
+	for _, msg := range messages {
+		if err := handle(msg); err != nil {
+			return err
+		}
+	}
+	if !config.Follow {
+		return nil
+	}
+
+	filtered := func(msg *Message) error {
+		if !config.match(msg) {
+			return nil
+		}
+		return handle(msg)
+	}
+	for {
+		// 先判断是否结束再读，保证结束前写入的日志都能读到
+		done := config.Done != nil && config.Done()
+		if partial, err = readMessages(reader, partial, filtered); err != nil {
+			return err
+		}
+		followEOFHook()
+		// 读到末尾后检查文件是否被轮转了：改名之后原来的文件不会再有新内容，切换到新文件继续读
+		state := checkRotated(path, file)
+		if state == fileRotated {
+			// 上次读到末尾之后、改名之前写入旧文件的内容要先读完
+			if partial, err = readMessages(reader, partial, filtered); err != nil {
+				return err
+			}
+			if len(partial) > 0 {
+				if err := filtered(parseMessage(partial)); err != nil {
+					return err
+				}
+			}
+			newFile, err := os.Open(path)
+			if err != nil {
+				return err
+			}
+			file.Close()
+			file = newFile
+		} else if state == fileTruncated {
+			if _, err := file.Seek(0, io.SeekStart); err != nil {
+				return err
+			}
+		}
+		if state != fileUnchanged {
+			reader.Reset(file)
+			partial = nil
+			continue
+		}
+		if done {
+			return nil
+		}
+		time.Sleep(followInterval)
+	}
+}
+
+// 读取一个完整的日志文件
+func readLogFile(path string, handle func(*Message) error) error {
+	file, err := openLogFile(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	partial, err := readMessages(bufio.NewReader(file), nil, handle)
+	if err != nil {
+		return err
+	}
+	if len(partial) > 0 {
+		return handle(parseMessage(partial))
+	}
+	return nil
+}
+
+const (
+	fileUnchanged = iota
+	fileRotated   //文件被改名，path 已经是一个新文件
+	fileTruncated //max-file 为 1 时文件被清空
+)
+
+func checkRotated(path string, file *os.File) int {
+	stat, err := os.Stat(path)
+	if err != nil {
+		// 轮转过程中新文件可能还没有创建
+		return fileUnchanged
+	}
+	current, err := file.Stat()
+	if err != nil {
+		return fileUnchanged
+	}
+	if !os.SameFile(stat, current) {
+		return fileRotated
+	}
+	offset, err := file.Seek(0, io.SeekCurrent)
+	if err == nil && offset > stat.Size() {
+		return fileTruncated
+	}
+	return fileUnchanged
+}
+
+// 读取到文件末尾为止的所有完整的行，返回末尾还没有写完的半行
+func readMessages(reader *bufio.Reader, partial []byte, handle func(*Message) error) ([]byte, error) {
+	for {
+		line, err := reader.ReadBytes('\n')
+		partial = append(partial, line...)
+		if err == io.EOF {
+			return partial, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		msg := parseMessage(partial)
+		partial = nil
+		if err := handle(msg); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// 解析一行日志，不是 json 格式的内容（比如旧版本直接写入的标准输出）当作标准输出处理
+func parseMessage(line []byte) *Message {
+	var msg Message
+	if err := json.Unmarshal(line, &msg); err != nil || msg.Stream == "" {
+		return &Message{Stream: Stdout, Log: string(line)}
+	}
+	return &msg
+}
@@ -0,0 +1,70 @@
+package logger
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+	"time"
+)
+
+func readAll(t *testing.T, logPath string, config *ReadConfig) []*Message {
+	var messages []*Message
+	err := ReadJSONLog(logPath, config, func(msg *Message) error {
+		messages = append(messages, msg)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("read log error: %v", err)
+	}
+	return messages
+}
+
+func TestJSONFileWriteAndRead(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mydocker-log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	logPath := path.Join(dir, "container.log")
+
+	w, err := NewJSONFileWriter(logPath, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := w.StreamWriter(Stdout)
+	stderr := w.StreamWriter(Stderr)
+	stdout.Write([]byte("line1\nli"))
+	stderr.Write([]byte("oops\n"))
+	stdout.Write([]byte("ne2\nno newline"))
+	w.Close()
+
+	all := readAll(t, logPath, &ReadConfig{Tail: -1, Stdout: true, Stderr: true})
+	expected := []string{"line1\n", "oops\n", "line2\n", "no newline"}
+	if len(all) != len(expected) {
+		t.Fatalf("expected %d messages, got %d", len(expected), len(all))
+	}
+	for i, msg := range all {
+		if msg.Log != expected[i] {
+			t.Errorf("message %d: expected %q, got %q", i, expected[i], msg.Log)
+		}
+	}
+	if all[1].Stream != Stderr {
+		t.Errorf("expected stderr stream, got %s", all[1].Stream)
+	}
+
+	onlyStderr := readAll(t, logPath, &ReadConfig{Tail: -1, Stderr: true})
+	if len(onlyStderr) != 1 || onlyStderr[0].Log != "oops\n" {
+		t.Errorf("unexpected stderr messages: %v", onlyStderr)
+	}
+
+	tail := readAll(t, logPath, &ReadConfig{Tail: 2, Stdout: true, Stderr: true})
+	if len(tail) != 2 || tail[0].Log != "line2\n" {
+		t.Errorf("unexpected tail messages: %v", tail)
+	}
+
+	future := readAll(t, logPath, &ReadConfig{Tail: -1, Stdout: true, Stderr: true, Since: time.Now().Add(time.Hour)})
+	if len(future) != 0 {
+		t.Errorf("expected no messages since the future, got %d", len(future))
+	}
+}
@@ -0,0 +1,220 @@
+package logger
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// json-file 日志支持的 --log-opt 选项
+const (
+	OptMaxSize  = "max-size" //单个日志文件的最大大小，例如 10m，不设置表示不限制
+	OptMaxFile  = "max-file" //最多保留的日志文件个数（包括正在写入的文件），默认为 1
+	OptCompress = "compress" //是否用 gzip 压缩轮转出来的日志文件
+)
+
+// 日志轮转的配置
+type rotateConfig struct {
+	maxSize  int64
+	maxFile  int
+	compress bool
+}
+
+// 检查 json-file 日志的选项是否合法
+func ValidateJSONFileOptions(opts map[string]string) error {
+	_, err := parseRotateConfig(opts)
+	return err
+}
+
+// 解析 --log-opt 中与轮转相关的选项
+func parseRotateConfig(opts map[string]string) (*rotateConfig, error) {
+	config := &rotateConfig{maxSize: -1, maxFile: 1}
+	for key, value := range opts {
+		switch key {
+		case OptMaxSize:
+			size, err := ParseSize(value)
+			if err != nil {
+				return nil, err
+			}
+			config.maxSize = size
+		case OptMaxFile:
+			maxFile, err := strconv.Atoi(value)
+			if err != nil || maxFile < 1 {
+				return nil, fmt.Errorf("invalid %s %q, must be a positive integer", OptMaxFile, value)
+			}
+			config.maxFile = maxFile
+		case OptCompress:
+			compress, err := strconv.ParseBool(value)
+			if err != nil {
+				return nil, fmt.Errorf("invalid %s %q", OptCompress, value)
+			}
+			config.compress = compress
+		default:
+			return nil, fmt.Errorf("unknown log opt %q", key)
+		}
+	}
+	if config.maxFile > 1 && config.maxSize < 0 {
+		return nil, fmt.Errorf("%s requires %s", OptMaxFile, OptMaxSize)
+	}
+	if config.compress && config.maxFile < 2 {
+		return nil, fmt.Errorf("%s requires %s greater than 1", OptCompress, OptMaxFile)
+	}
+	return config, nil
+}
+
+// 解析带单位的大小，例如 512、100k、10m、1g，单位不区分大小写
+func ParseSize(value string) (int64, error) {
+	s := strings.ToLower(strings.TrimSpace(value))
+	s = strings.TrimSuffix(s, "b")
+	unit := int64(1)
+	if s != "" {
+		switch s[len(s)-1] {
+		case 'k':
+			unit = 1 << 10
+		case 'm':
+			unit = 1 << 20
+		case 'g':
+			unit = 1 << 30
+		}
+		if unit != 1 {
+			s = s[:len(s)-1]
+		}
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("invalid size %q", value)
+	}
+	return n * unit, nil
+}
+
+// 第 n 个轮转出来的日志文件，n 越大越旧，例如 container.log.1、container.log.2.gz
+func rotatedPath(path string, n int, compress bool) string {
+	p := fmt.Sprintf("%s.%d", path, n)
+	if compress {
+		p += ".gz"
+	}
+	return p
+}
+
+// 正在压缩的日志文件，压缩成 .1.gz 之后删除
+func compressingPath(path string) string {
+	return rotatedPath(path, 1, false) + ".tmp"
+}
+
+// 日志轮转：
+// 1.删除最旧的文件，其余的文件编号依次加一
+// 2.当前的日志文件改名为 .1；需要压缩时改名为 .1.tmp 并返回这个文件，由调用者在后台压缩成 .1.gz
+// max-file 为 1 时没有可以保留的旧文件，直接清空当前文件
+func rotate(path string, config *rotateConfig) (string, error) {
+	if config.maxFile < 2 {
+		return "", os.Truncate(path, 0)
+	}
+	last := config.maxFile - 1
+	os.Remove(rotatedPath(path, last, config.compress))
+	for n := last - 1; n >= 1; n-- {
+		from := rotatedPath(path, n, config.compress)
+		if err := os.Rename(from, rotatedPath(path, n+1, config.compress)); err != nil && !os.IsNotExist(err) {
+			return "", err
+		}
+	}
+	if !config.compress {
+		return "", os.Rename(path, rotatedPath(path, 1, false))
+	}
+	// 先改名再压缩，保证读日志的进程任何时候都能看到完整的文件
+	tmpPath := compressingPath(path)
+	if err := os.Rename(path, tmpPath); err != nil {
+		return "", err
+	}
+	return tmpPath, nil
+}
+
+// 把轮转出来的文件压缩成 dst，完成后删除未压缩的文件
+func compressRotated(src, dst string) error {
+	if err := compressFile(src, dst); err != nil {
+		return err
+	}
+	return os.Remove(src)
+}
+
+func compressFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst+".tmp", os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	gz := gzip.NewWriter(out)
+	if _, err := io.Copy(gz, in); err != nil {
+		out.Close()
+		return err
+	}
+	if err := gz.Close(); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	return os.Rename(dst+".tmp", dst)
+}
+
+// 按从旧到新的顺序列出已经轮转出来的日志文件，压缩和未压缩的都可能存在
+func rotatedFiles(path string) []string {
+	var files []string
+	for n := 1; ; n++ {
+		plain := rotatedPath(path, n, false)
+		gz := rotatedPath(path, n, true)
+		found := false
+		// 压缩过程中 .1.tmp 和 .1.gz 可能同时存在，此时使用未压缩的文件
+		if _, err := os.Stat(plain); err == nil {
+			files = append(files, plain)
+			found = true
+		} else if _, err := os.Stat(compressingPath(path)); n == 1 && err == nil {
+			files = append(files, compressingPath(path))
+			found = true
+		} else if _, err := os.Stat(gz); err == nil {
+			files = append(files, gz)
+			found = true
+		}
+		if !found {
+			break
+		}
+	}
+	for i, j := 0, len(files)-1; i < j; i, j = i+1, j-1 {
+		files[i], files[j] = files[j], files[i]
+	}
+	return files
+}
+
+// 打开一个日志文件，.gz 结尾的文件自动解压
+func openLogFile(path string) (io.ReadCloser, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasSuffix(path, ".gz") {
+		return file, nil
+	}
+	gz, err := gzip.NewReader(file)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return &gzipFile{Reader: gz, file: file}, nil
+}
+
+type gzipFile struct {
+	*gzip.Reader
+	file *os.File
+}
+
+func (f *gzipFile) Close() error {
+	f.Reader.Close()
+	return f.file.Close()
+}
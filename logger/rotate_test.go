@@ -0,0 +1,137 @@
+package logger
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"testing"
+)
+
+func TestRotateAndReadAcrossFiles(t *testing.T) {
+	for _, compress := range []bool{false, true} {
+		dir, err := ioutil.TempDir("", "mydocker-rotate")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+		logPath := path.Join(dir, "container.log")
+
+		opts := map[string]string{OptMaxSize: "300", OptMaxFile: "3", OptCompress: fmt.Sprint(compress)}
+		w, err := NewJSONFileWriter(logPath, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		stdout := w.StreamWriter(Stdout)
+		for i := 0; i < 20; i++ {
+			fmt.Fprintf(stdout, "line %02d\n", i)
+		}
+		w.Close()
+
+		// Close 之后后台的压缩已经完成，不应该留下临时文件
+		entries, err := ioutil.ReadDir(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, entry := range entries {
+			if strings.HasSuffix(entry.Name(), ".tmp") {
+				t.Errorf("compress=%v: temporary file %s left after close", compress, entry.Name())
+			}
+		}
+
+		files := rotatedFiles(logPath)
+		if len(files) != 2 {
+			t.Fatalf("compress=%v: expected 2 rotated files, got %v", compress, files)
+		}
+		for _, file := range files {
+			if strings.HasSuffix(file, ".gz") != compress {
+				t.Errorf("compress=%v: unexpected rotated file %s", compress, file)
+			}
+		}
+
+		// 最旧的日志已经被删除，剩下的日志按顺序连续，并且以最后一行结尾
+		all := readAll(t, logPath, &ReadConfig{Tail: -1, Stdout: true})
+		if len(all) == 0 || len(all) >= 20 {
+			t.Fatalf("compress=%v: unexpected message count %d", compress, len(all))
+		}
+		first := 20 - len(all)
+		for i, msg := range all {
+			if expected := fmt.Sprintf("line %02d\n", first+i); msg.Log != expected {
+				t.Errorf("compress=%v: message %d: expected %q, got %q", compress, i, expected, msg.Log)
+			}
+		}
+
+		tail := readAll(t, logPath, &ReadConfig{Tail: 1, Stdout: true})
+		if len(tail) != 1 || tail[0].Log != "line 19\n" {
+			t.Errorf("compress=%v: unexpected tail messages: %v", compress, tail)
+		}
+	}
+}
+
+func TestParseRotateConfig(t *testing.T) {
+	config, err := parseRotateConfig(map[string]string{OptMaxSize: "10m", OptMaxFile: "3"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.maxSize != 10<<20 || config.maxFile != 3 {
+		t.Errorf("unexpected config %+v", config)
+	}
+	invalid := []map[string]string{
+		{OptMaxSize: "ten"},
+		{OptMaxFile: "3"},
+		{OptMaxSize: "1k", OptMaxFile: "0"},
+		{OptMaxSize: "1k", OptCompress: "true"},
+		{"unknown": "1"},
+	}
+	for _, opts := range invalid {
+		if _, err := parseRotateConfig(opts); err == nil {
+			t.Errorf("expected error for %v", opts)
+		}
+	}
+}
+
+func TestFollowAcrossRotation(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mydocker-follow")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	logPath := path.Join(dir, "container.log")
+
+	// 每行日志大约 75 字节，一个文件只能放下两行
+	w, err := NewJSONFileWriter(logPath, map[string]string{OptMaxSize: "200", OptMaxFile: "5"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := w.StreamWriter(Stdout)
+	fmt.Fprintf(stdout, "line %02d\n", 0)
+
+	// 读端每次读到末尾后写入两行：第一行追加到旧文件，第二行触发轮转，
+	// 读端必须先读完旧文件再切换到新文件，否则第一行会丢失
+	next := 1
+	followEOFHook = func() {
+		if next >= 10 {
+			return
+		}
+		for i := 0; i < 2; i++ {
+			fmt.Fprintf(stdout, "line %02d\n", next)
+			next++
+		}
+		if next >= 10 {
+			w.Close()
+		}
+	}
+	defer func() { followEOFHook = func() {} }()
+
+	finished := func() bool { return next >= 10 }
+	all := readAll(t, logPath, &ReadConfig{Tail: -1, Stdout: true, Follow: true, Done: finished})
+	if len(all) != 11 {
+		t.Fatalf("expected 11 messages, got %d", len(all))
+	}
+	for i, msg := range all {
+		if expected := fmt.Sprintf("line %02d\n", i); msg.Log != expected {
+			t.Errorf("message %d: expected %q, got %q", i, expected, msg.Log)
+		}
+	}
+}
@@ -0,0 +1,200 @@
+package logger
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// syslog 驱动支持的 --log-opt 选项
+const (
+	OptSyslogAddress  = "syslog-address"  //syslog 服务的地址，例如 unix:///dev/log、udp://127.0.0.1:514
+	OptSyslogFacility = "syslog-facility" //日志的 facility，默认为 daemon
+	OptTag            = "tag"             //日志的 APP-NAME，默认为容器Id
+)
+
+const defaultSyslogAddress = "unix:///dev/log"
+
+var syslogFacilities = map[string]int{
+	"kern": 0, "user": 1, "mail": 2, "daemon": 3, "auth": 4, "syslog": 5, "lpr": 6, "news": 7,
+	"uucp": 8, "cron": 9, "authpriv": 10, "ftp": 11,
+	"local0": 16, "local1": 17, "local2": 18, "local3": 19,
+	"local4": 20, "local5": 21, "local6": 22, "local7": 23,
+}
+
+// syslog 的 severity，标准输出记为 info，标准错误记为 err
+const (
+	severityErr  = 3
+	severityInfo = 6
+)
+
+type syslogConfig struct {
+	network  string
+	address  string
+	facility int
+	tag      string
+}
+
+func parseSyslogConfig(opts map[string]string) (*syslogConfig, error) {
+	config := &syslogConfig{facility: syslogFacilities["daemon"]}
+	address := defaultSyslogAddress
+	for key, value := range opts {
+		switch key {
+		case OptSyslogAddress:
+			address = value
+		case OptSyslogFacility:
+			facility, ok := syslogFacilities[value]
+			if !ok {
+				return nil, fmt.Errorf("invalid %s %q", OptSyslogFacility, value)
+			}
+			config.facility = facility
+		case OptTag:
+			config.tag = value
+		default:
+			return nil, fmt.Errorf("unknown log opt %q for log driver %s", key, SyslogDriver)
+		}
+	}
+	u, err := url.Parse(address)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s %q: %v", OptSyslogAddress, address, err)
+	}
+	switch u.Scheme {
+	case "unix", "unixgram":
+		if u.Path == "" {
+			return nil, fmt.Errorf("invalid %s %q, missing socket path", OptSyslogAddress, address)
+		}
+		config.network, config.address = u.Scheme, u.Path
+	case "udp":
+		host := u.Host
+		if _, _, err := net.SplitHostPort(host); err != nil {
+			host = net.JoinHostPort(host, "514")
+		}
+		config.network, config.address = u.Scheme, host
+	default:
+		return nil, fmt.Errorf("invalid %s %q, only unix, unixgram and udp are supported", OptSyslogAddress, address)
+	}
+	return config, nil
+}
+
+// syslog 日志驱动，以 RFC 5424 的格式把每行输出发送给 syslog 服务
+type SyslogWriter struct {
+	mu       sync.Mutex
+	config   *syslogConfig
+	hostname string
+	conn     net.Conn
+	stream   bool //是否是流式连接，流式连接需要按 RFC 6587 加上长度前缀
+	streams  streamSet
+}
+
+func NewSyslogWriter(ctx *DriverContext) (*SyslogWriter, error) {
+	config, err := parseSyslogConfig(ctx.Opts)
+	if err != nil {
+		return nil, err
+	}
+	if config.tag == "" {
+		config.tag = ctx.ContainerID
+	}
+	hostname, err := os.Hostname()
+	if err != nil || hostname == "" {
+		hostname = "-"
+	}
+	w := &SyslogWriter{config: config, hostname: hostname}
+	if err := w.connect(); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+// unix 地址先尝试数据报套接字（比如 /dev/log），失败再尝试流式套接字
+func (w *SyslogWriter) connect() error {
+	if w.config.network != "unix" {
+		conn, err := net.Dial(w.config.network, w.config.address)
+		if err != nil {
+			return fmt.Errorf("connect syslog %s error %v", w.config.address, err)
+		}
+		w.conn, w.stream = conn, false
+		return nil
+	}
+	if conn, err := net.Dial("unixgram", w.config.address); err == nil {
+		w.conn, w.stream = conn, false
+		return nil
+	}
+	conn, err := net.Dial("unix", w.config.address)
+	if err != nil {
+		return fmt.Errorf("connect syslog %s error %v", w.config.address, err)
+	}
+	w.conn, w.stream = conn, true
+	return nil
+}
+
+// 格式为 <PRI>1 TIMESTAMP HOSTNAME APP-NAME PROCID MSGID STRUCTURED-DATA MSG
+func (w *SyslogWriter) format(msg *Message) string {
+	severity := severityInfo
+	if msg.Stream == Stderr {
+		severity = severityErr
+	}
+	return fmt.Sprintf("<%d>1 %s %s %s - - - %s",
+		w.config.facility*8+severity,
+		msg.Time.Format("2006-01-02T15:04:05.000000Z07:00"),
+		w.hostname,
+		appName(w.config.tag),
+		strings.TrimSuffix(msg.Log, "\n"))
+}
+
+// 发送一条日志，连接断开（比如 syslog 服务重启）时重连一次
+func (w *SyslogWriter) WriteMessage(msg *Message) error {
+	line := w.format(msg)
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	err := w.send(line)
+	if err == nil {
+		return nil
+	}
+	w.conn.Close()
+	if err := w.connect(); err != nil {
+		return err
+	}
+	return w.send(line)
+}
+
+func (w *SyslogWriter) send(line string) error {
+	if w.stream {
+		line = fmt.Sprintf("%d %s", len(line), line)
+	}
+	w.conn.SetWriteDeadline(time.Now().Add(5 * time.Second))
+	_, err := w.conn.Write([]byte(line))
+	return err
+}
+
+func (w *SyslogWriter) StreamWriter(stream string) io.Writer {
+	return w.streams.newStream(stream, w)
+}
+
+func (w *SyslogWriter) Close() error {
+	w.streams.flush()
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.conn.Close()
+}
+
+// APP-NAME 最长 48 个字符，并且只能包含可打印的 ASCII 字符
+func appName(tag string) string {
+	name := strings.Map(func(r rune) rune {
+		if r < 33 || r > 126 {
+			return '_'
+		}
+		return r
+	}, tag)
+	if name == "" {
+		return "-"
+	}
+	if len(name) > 48 {
+		name = name[:48]
+	}
+	return name
+}
@@ -0,0 +1,103 @@
+package logger
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path"
+	"regexp"
+	"testing"
+	"time"
+)
+
+// RFC 5424 格式的日志：<PRI>1 TIMESTAMP HOSTNAME APP-NAME - - - MSG
+var rfc5424 = regexp.MustCompile(`^<(\d+)>1 \S+ \S+ (\S+) - - - (.*)$`)
+
+func receive(t *testing.T, conn net.PacketConn) []string {
+	buf := make([]byte, 4096)
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	n, _, err := conn.ReadFrom(buf)
+	if err != nil {
+		t.Fatalf("read syslog message error: %v", err)
+	}
+	m := rfc5424.FindStringSubmatch(string(buf[:n]))
+	if m == nil {
+		t.Fatalf("message %q is not in RFC 5424 format", buf[:n])
+	}
+	return m[1:]
+}
+
+func testSyslogDriver(t *testing.T, listener net.PacketConn, address string) {
+	w, err := NewDriver(SyslogDriver, &DriverContext{
+		ContainerID: "1234567890",
+		Opts:        map[string]string{OptSyslogAddress: address, OptSyslogFacility: "local0"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	fmt.Fprint(w.StreamWriter(Stdout), "hello\n")
+	fmt.Fprint(w.StreamWriter(Stderr), "oops\n")
+
+	// local0 = 16，info = 6，err = 3
+	expected := [][]string{{"134", "1234567890", "hello"}, {"131", "1234567890", "oops"}}
+	for _, want := range expected {
+		got := receive(t, listener)
+		if fmt.Sprint(got) != fmt.Sprint(want) {
+			t.Errorf("%s: expected %v, got %v", address, want, got)
+		}
+	}
+}
+
+func TestSyslogDriverUDP(t *testing.T) {
+	listener, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	testSyslogDriver(t, listener, "udp://"+listener.LocalAddr().String())
+}
+
+func TestSyslogDriverUnixSocket(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mydocker-syslog")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	socketPath := path.Join(dir, "log.sock")
+	listener, err := net.ListenPacket("unixgram", socketPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	testSyslogDriver(t, listener, "unix://"+socketPath)
+}
+
+func TestValidateDriver(t *testing.T) {
+	valid := map[string]map[string]string{
+		"":             nil,
+		JSONFileDriver: {OptMaxSize: "10m"},
+		SyslogDriver:   {OptSyslogAddress: "udp://127.0.0.1", OptTag: "web"},
+		NoneDriver:     nil,
+	}
+	for driver, opts := range valid {
+		if err := ValidateDriver(driver, opts); err != nil {
+			t.Errorf("driver %q with %v: unexpected error %v", driver, opts, err)
+		}
+	}
+	invalid := map[string]map[string]string{
+		"journald":     nil,
+		JSONFileDriver: {OptSyslogAddress: "udp://127.0.0.1"},
+		SyslogDriver:   {OptSyslogAddress: "tcp://127.0.0.1:514"},
+		NoneDriver:     {OptMaxSize: "10m"},
+	}
+	for driver, opts := range invalid {
+		if err := ValidateDriver(driver, opts); err == nil {
+			t.Errorf("driver %q with %v: expected error", driver, opts)
+		}
+	}
+	if IsReadable(SyslogDriver) || IsReadable(NoneDriver) || !IsReadable("") {
+		t.Errorf("only json-file logs should be readable")
+	}
+}
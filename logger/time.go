@@ -0,0 +1,27 @@
+package logger
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// 解析 --since/--until 的参数，支持以下三种格式：
+// 1.RFC3339 格式的时间，例如 2019-12-20T10:00:00Z
+// 2.相对于当前时间的时长，例如 10m 表示 10 分钟之前
+// 3.unix 时间戳（秒）
+func ParseTime(value string, now time.Time) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339Nano, value); err == nil {
+		return t, nil
+	}
+	if d, err := time.ParseDuration(value); err == nil {
+		return now.Add(-d), nil
+	}
+	if sec, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return time.Unix(sec, 0), nil
+	}
+	return time.Time{}, fmt.Errorf("invalid time %q", value)
+}
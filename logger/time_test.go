@@ -0,0 +1,28 @@
+package logger
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseTime(t *testing.T) {
+	now := time.Date(2019, 12, 20, 10, 0, 0, 0, time.UTC)
+	tests := map[string]time.Time{
+		"2019-12-20T09:00:00Z": time.Date(2019, 12, 20, 9, 0, 0, 0, time.UTC),
+		"10m":                  now.Add(-10 * time.Minute),
+		"1576832400":           time.Unix(1576832400, 0),
+	}
+	for value, expected := range tests {
+		got, err := ParseTime(value, now)
+		if err != nil {
+			t.Errorf("parse %s error: %v", value, err)
+			continue
+		}
+		if !got.Equal(expected) {
+			t.Errorf("parse %s: expected %v, got %v", value, expected, got)
+		}
+	}
+	if _, err := ParseTime("yesterday", now); err == nil {
+		t.Errorf("expected error for invalid time")
+	}
+}
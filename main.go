@@ -2,8 +2,11 @@ package main
 
 import (
 	"github.com/Sirupsen/logrus"
+	"github.com/kkBill/mydocker/container"
+	_ "github.com/kkBill/mydocker/nsenter"
 	"github.com/urfave/cli"
 	"os"
+	"strings"
 )
 
 func main() {
@@ -16,12 +19,71 @@ func main() {
 		commitCommand,
 		listCommand,
 		logCommand,
-		execCommand, // 实现了，但是有bug，还没解决
+		execCommand,
+		inspectCommand,
+		attachCommand,
 		networkCommand,
+		podCommand,
+		pauseCommand,
 		stopCommand,
 		removeCommand,
 	}
-	if err := app.Run(os.Args); err != nil {
+	app.Before = func(context *cli.Context) error {
+		// 普通用户运行时，容器信息、镜像等保存在用户自己的目录下
+		if container.IsRootless() {
+			return container.SetupRootlessPaths()
+		}
+		return nil
+	}
+	if err := app.Run(expandShortFlags(os.Args)); err != nil {
 		logrus.Fatal(err)
 	}
-}
\ No newline at end of file
+}
+
+// cli 库不支持合并书写的短参数，这里把 run -dit 这样的写法展开成 run -d -i -t
+func expandShortFlags(args []string) []string {
+	if len(args) < 2 || args[1] != "run" {
+		return args
+	}
+	boolFlags := map[string]bool{}
+	for _, flag := range runCommand.Flags {
+		if boolFlag, ok := flag.(cli.BoolFlag); ok {
+			boolFlags[boolFlag.Name] = true
+		}
+	}
+	expanded := []string{args[0], args[1]}
+	for i := 2; i < len(args); i++ {
+		arg := args[i]
+		// 第一个非参数的位置就是镜像名，之后的内容原样保留
+		if !strings.HasPrefix(arg, "-") {
+			return append(expanded, args[i:]...)
+		}
+		name := strings.TrimLeft(arg, "-")
+		if combined := splitShortFlags(name, boolFlags); combined != nil {
+			expanded = append(expanded, combined...)
+			continue
+		}
+		expanded = append(expanded, arg)
+		// 带值的参数，下一个位置是参数值
+		if !boolFlags[name] && !strings.Contains(name, "=") && i+1 < len(args) {
+			i++
+			expanded = append(expanded, args[i])
+		}
+	}
+	return expanded
+}
+
+// 由多个单字母 bool 参数合并而成时返回展开后的参数，否则返回 nil
+func splitShortFlags(name string, boolFlags map[string]bool) []string {
+	if len(name) < 2 || boolFlags[name] {
+		return nil
+	}
+	var flags []string
+	for _, c := range name {
+		if !boolFlags[string(c)] {
+			return nil
+		}
+		flags = append(flags, "-"+string(c))
+	}
+	return flags
+}
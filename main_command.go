@@ -5,18 +5,26 @@ import (
 	"github.com/Sirupsen/logrus"
 	"github.com/kkBill/mydocker/cgroup/subsystem"
 	"github.com/kkBill/mydocker/container"
+	"github.com/kkBill/mydocker/logger"
 	"github.com/kkBill/mydocker/network"
 	"github.com/urfave/cli"
+	"net"
 	"os"
+	"path"
+	"time"
 )
 
 var runCommand = cli.Command{
 	Name:  "run",
-	Usage: `Create a container with namespace and cgroups limit ie: mydocker run -ti [image] [command]`,
+	Usage: `Create a container with namespace and cgroups limit ie: mydocker run -it [image] [command]`,
 	Flags: []cli.Flag{
 		cli.BoolFlag{
-			Name:  "ti",
-			Usage: "enable tty",
+			Name:  "i",
+			Usage: "keep stdin open",
+		},
+		cli.BoolFlag{
+			Name:  "t",
+			Usage: "allocate a tty",
 		},
 		cli.BoolFlag{
 			Name:  "d",
@@ -48,12 +56,137 @@ var runCommand = cli.Command{
 		},
 		cli.StringFlag{
 			Name:  "net",
-			Usage: "container network",
+			Usage: "container network, or host|container:<name> to share the network namespace",
+		},
+		cli.StringFlag{
+			Name:  "pod",
+			Usage: "run the container in a pod, sharing its network, ipc and uts namespaces",
+		},
+		cli.StringFlag{
+			Name:  "pid",
+			Usage: "pid namespace to use: host|container:<name>",
+		},
+		cli.StringFlag{
+			Name:  "ipc",
+			Usage: "ipc namespace to use: host|container:<name>",
+		},
+		cli.StringFlag{
+			Name:  "uts",
+			Usage: "uts namespace to use: host|container:<name>",
+		},
+		cli.BoolFlag{
+			Name:  "init",
+			Usage: "run an init inside the container that forwards signals and reaps processes",
+		},
+		cli.StringFlag{
+			Name:  "timeout",
+			Usage: "stop the container after this duration (e.g. 30s, 5m), killing it if it does not stop in 10s",
+		},
+		cli.BoolFlag{
+			Name:  "report",
+			Usage: "print and store a resource usage summary when the container exits (for -d containers, see it with inspect)",
+		},
+		cli.StringFlag{
+			Name:  "cgroupns",
+			Usage: "cgroup namespace to use: host|private, default is private",
+		},
+		cli.StringFlag{
+			Name:  "time-offset",
+			Usage: "run in a time namespace with clock offsets: monotonic=<offset>,boottime=<offset>",
 		},
 		cli.StringSliceFlag{
 			Name:  "p",
 			Usage: "port mapping",
 		},
+		cli.StringFlag{
+			Name:  "w, workdir",
+			Usage: "working directory inside the container",
+		},
+		cli.StringFlag{
+			Name:  "u, user",
+			Usage: "username or uid, format: name|uid[:group|gid]",
+		},
+		cli.StringFlag{
+			Name:  "userns",
+			Usage: "user namespace mode, host disables the user namespace and is required to share the pid, net or ipc namespace",
+		},
+		cli.StringFlag{
+			Name:  "userns-remap",
+			Usage: "remap container ids to the subordinate ids of user[:group] in /etc/subuid and /etc/subgid",
+		},
+		cli.StringFlag{
+			Name:  "hostname",
+			Usage: "container host name, default is the container id",
+		},
+		cli.StringFlag{
+			Name:  "domainname",
+			Usage: "container NIS domain name",
+		},
+		cli.StringSliceFlag{
+			Name:  "add-host",
+			Usage: "add a custom host-to-IP mapping (name:ip)",
+		},
+		cli.StringSliceFlag{
+			Name:  "dns",
+			Usage: "set custom dns servers",
+		},
+		cli.StringSliceFlag{
+			Name:  "dns-search",
+			Usage: "set custom dns search domains",
+		},
+		cli.StringFlag{
+			Name:  "log-driver",
+			Value: logger.DefaultDriver,
+			Usage: "log driver for the container: json-file, syslog or none",
+		},
+		cli.StringSliceFlag{
+			Name:  "log-opt",
+			Usage: "log driver options, ie: --log-opt max-size=10m --log-opt max-file=3",
+		},
+		cli.StringSliceFlag{
+			Name:  "cap-add",
+			Usage: "add linux capabilities, ALL for all capabilities",
+		},
+		cli.StringSliceFlag{
+			Name:  "cap-drop",
+			Usage: "drop linux capabilities, ALL for all capabilities",
+		},
+		cli.BoolFlag{
+			Name:  "privileged",
+			Usage: "give all capabilities to the container",
+		},
+		cli.StringSliceFlag{
+			Name:  "device",
+			Usage: "add a host device to the container, format: /dev/host[:/dev/container[:rwm]]",
+		},
+		cli.StringFlag{
+			Name:  "shm-size",
+			Usage: "size of /dev/shm, ie: 64m",
+		},
+		cli.StringFlag{
+			Name:  "pids-limit",
+			Usage: "maximum number of processes in the container, -1 for unlimited",
+		},
+		cli.StringSliceFlag{
+			Name:  "ulimit",
+			Usage: "ulimit options, ie: --ulimit nofile=1024:2048",
+		},
+		cli.IntFlag{
+			Name:  "oom-score-adj",
+			Usage: "tune the container's oom preferences, range [-1000, 1000]",
+		},
+		cli.StringSliceFlag{
+			Name:  "sysctl",
+			Usage: "namespaced kernel parameters, ie: --sysctl net.core.somaxconn=1024",
+		},
+		cli.BoolFlag{
+			Name:  "read-only",
+			Usage: "mount the container's root filesystem as read only",
+		},
+		cli.StringSliceFlag{
+			Name:  "security-opt",
+			Usage: "security options, ie: --security-opt seccomp=profile.json|default|unconfined, --security-opt landlock=policy.json",
+		},
 	},
 
 	Action: func(context *cli.Context) error {
@@ -74,31 +207,241 @@ var runCommand = cli.Command{
 		imageName := cmdArray[0]
 		cmdArray = cmdArray[1:]
 
-		tty := context.Bool("ti")
+		tty := context.Bool("t")
+		interactive := context.Bool("i")
 		detach := context.Bool("d")
 
-		if tty && detach {
-			return fmt.Errorf("ti and d parameter can not both provided.")
+		var devices []*container.Device
+		for _, spec := range context.StringSlice("device") {
+			device, err := container.ParseDevice(spec)
+			if err != nil {
+				return err
+			}
+			devices = append(devices, device)
+		}
+		shmSize := int64(container.DefaultShmSize)
+		if context.String("shm-size") != "" {
+			size, err := logger.ParseSize(context.String("shm-size"))
+			if err != nil {
+				return fmt.Errorf("invalid shm-size: %v", err)
+			}
+			shmSize = size
 		}
+
+		for _, spec := range context.StringSlice("ulimit") {
+			if _, err := container.ParseUlimit(spec); err != nil {
+				return err
+			}
+		}
+		oomScoreAdj := context.Int("oom-score-adj")
+		if oomScoreAdj < container.OomScoreAdjMin || oomScoreAdj > container.OomScoreAdjMax {
+			return fmt.Errorf("invalid oom-score-adj %d, must be in range [%d, %d]", oomScoreAdj, container.OomScoreAdjMin, container.OomScoreAdjMax)
+		}
+		pidsLimit, err := parsePidsLimit(context.String("pids-limit"))
+		if err != nil {
+			return err
+		}
+
 		resconfig := &subsystem.ResourceConfig{
 			MemoryLimit: context.String("m"),
 			CpuShare:    context.String("cpushare"),
 			CpuSet:      context.String("cpuset"),
+			Devices:     container.DeviceCgroupRules(devices, context.Bool("privileged")),
+			PidsLimit:   pidsLimit,
+			Ulimits:     context.StringSlice("ulimit"),
+			OomScoreAdj: oomScoreAdj,
 		}
-		volume := context.String("v")
-		containerName := context.String("name")
 		network := context.String("net")
+		namespaces := &container.NamespaceConfig{
+			Pid: context.String("pid"),
+			Ipc: context.String("ipc"),
+			Uts: context.String("uts"),
+		}
+		// --net 为 host 或 container:<name> 时共享 network namespace，否则是要连接的网络
+		if container.IsNamespaceMode(network) {
+			namespaces.Net = network
+			network = ""
+			if len(context.StringSlice("p")) > 0 {
+				return fmt.Errorf("port mapping can not be used with --net=%s", namespaces.Net)
+			}
+		}
+		for name, mode := range map[string]string{"pid": namespaces.Pid, "ipc": namespaces.Ipc, "uts": namespaces.Uts, "net": namespaces.Net} {
+			if _, err := container.ParseNamespaceMode(name, mode); err != nil {
+				return err
+			}
+		}
+		if namespaces.Uts != "" && context.String("hostname") != "" {
+			return fmt.Errorf("--hostname can not be used with --uts=%s", namespaces.Uts)
+		}
+		if namespaces.Cgroup, err = container.ParseCgroupnsMode(context.String("cgroupns")); err != nil {
+			return err
+		}
+		var timeout time.Duration
+		if spec := context.String("timeout"); spec != "" {
+			if timeout, err = container.ParseDuration(spec); err != nil || timeout <= 0 {
+				return fmt.Errorf("invalid timeout %q, must be a positive duration such as 30s or 5m", spec)
+			}
+		}
+		var timeOffsets *container.TimeOffsets
+		if spec := context.String("time-offset"); spec != "" {
+			if err := container.CheckTimeNamespace(); err != nil {
+				return err
+			}
+			if timeOffsets, err = container.ParseTimeOffsets(spec); err != nil {
+				return err
+			}
+		}
+		// --pod 时加入 pod 的 infra 进程的 network、ipc、uts namespace，网络和主机名都由 pod 统一配置
+		podName := context.String("pod")
+		var pod *container.PodInfo
+		if podName != "" {
+			if context.String("net") != "" || len(context.StringSlice("p")) > 0 || namespaces.Ipc != "" || namespaces.Uts != "" || context.String("hostname") != "" {
+				return fmt.Errorf("--net, -p, --ipc, --uts and --hostname can not be used with --pod, set them when creating the pod")
+			}
+			var err error
+			if pod, err = container.GetPodInfo(podName); err != nil {
+				return err
+			}
+			namespaces.Net = container.NamespacePodPrefix + podName
+			namespaces.Ipc = container.NamespacePodPrefix + podName
+			namespaces.Uts = container.NamespacePodPrefix + podName
+		}
+		if _, err := namespacePaths(namespaces); err != nil {
+			return err
+		}
+		logDriver := context.String("log-driver")
+		logOpts, err := parseLogOpts(logDriver, context.StringSlice("log-opt"))
+		if err != nil {
+			return err
+		}
+
+		extraHosts := context.StringSlice("add-host")
+		for _, extraHost := range extraHosts {
+			if _, _, err := container.ParseExtraHost(extraHost); err != nil {
+				return err
+			}
+		}
+		if workDir := context.String("workdir"); workDir != "" && !path.IsAbs(workDir) {
+			return fmt.Errorf("workdir %q is not an absolute path", workDir)
+		}
+		userns, err := container.NewUsernsConfig(context.String("userns"), context.String("userns-remap"))
+		if err != nil {
+			return err
+		}
+		if err := namespaces.CheckUserns(userns); err != nil {
+			return err
+		}
+		// 特权容器以全部 capability 为基础，否则以默认的 capability 为基础
+		baseCaps := container.DefaultCapabilities
+		if context.Bool("privileged") {
+			baseCaps = container.AllCapabilities()
+		}
+		caps, err := container.ResolveCapabilities(baseCaps, context.StringSlice("cap-add"), context.StringSlice("cap-drop"))
+		if err != nil {
+			return err
+		}
+		security, err := parseSecurityOpts(context.StringSlice("security-opt"), context.Bool("privileged"))
+		if err != nil {
+			return err
+		}
+		sysctls, err := container.ParseSysctls(context.StringSlice("sysctl"), namespaces.CloneFlags())
+		if err != nil {
+			return err
+		}
+		dns := context.StringSlice("dns")
+		for _, ns := range dns {
+			if net.ParseIP(ns) == nil {
+				return fmt.Errorf("invalid dns server %q", ns)
+			}
+		}
 
+		// monitor 进程沿用前台 run 进程生成的容器Id
+		containerID := os.Getenv(ENV_MONITOR_ID)
+		isMonitor := containerID != ""
+		if !isMonitor {
+			// generate container ID (random 10 bits number)
+			containerID = generateRandomID(10)
+		}
+		containerName := context.String("name")
+		if containerName == "" {
+			containerName = containerID
+		}
+
+		// 后台运行时由 monitor 进程启动容器，前台进程等容器启动后直接退出
+		if detach && !isMonitor {
+			return startMonitor(containerID, containerName)
+		}
+		if isMonitor {
+			setupMonitor()
+		}
+
+		containerInfo := &container.ContainerInfo{
+			Id:           containerID,
+			Name:         containerName,
+			Volume:       context.String("v"),
+			PortMapping:  context.StringSlice("p"),
+			Tty:          tty,
+			Interactive:  interactive,
+			Detach:       detach,
+			LogDriver:    logDriver,
+			LogOpts:      logOpts,
+			Hostname:     context.String("hostname"),
+			Domainname:   context.String("domainname"),
+			ExtraHosts:   extraHosts,
+			Dns:          dns,
+			DnsSearch:    context.StringSlice("dns-search"),
+			WorkingDir:   context.String("workdir"),
+			User:         context.String("user"),
+			Userns:       userns,
+			Privileged:   context.Bool("privileged"),
+			CapAdd:       context.StringSlice("cap-add"),
+			CapDrop:      context.StringSlice("cap-drop"),
+			Capabilities: caps,
+			SecurityOpt:  context.StringSlice("security-opt"),
+			Seccomp:      security.Seccomp,
+			Landlock:     security.Landlock,
+			ReadOnly:     context.Bool("read-only"),
+			Devices:      devices,
+			ShmSize:      shmSize,
+			Resources:    resconfig,
+			Sysctls:      sysctls,
+			Namespaces:   namespaces,
+			TimeOffsets:  timeOffsets,
+			Init:         context.Bool("init"),
+			Timeout:      timeout,
+			Report:       context.Bool("report"),
+		}
+		if pod != nil {
+			containerInfo.Pod = pod.Name
+			containerInfo.IPAddress = pod.IPAddress
+		}
+		if containerInfo.Hostname == "" {
+			hostname, err := sharedHostname(namespaces.Uts, containerID)
+			if err != nil {
+				return err
+			}
+			containerInfo.Hostname = hostname
+		}
 		//envSlice := context.StringSlice("e")
-		portmapping := context.StringSlice("p")
 
 		logrus.Infof("tty %v", tty)
-		//Run(tty, cmdArray, resconfig, volume, containerName)
-		Run(tty, cmdArray, resconfig, volume, containerName, imageName, network, portmapping)
+		if exitCode := Run(containerInfo, cmdArray, resconfig, imageName, network); exitCode != 0 {
+			os.Exit(exitCode)
+		}
 		return nil
 	},
 }
 
+// pod 的 infra 进程，由 pod create 启动，不要在外部调用
+var pauseCommand = cli.Command{
+	Name:   "pause",
+	Usage:  "Pod infra process holding the shared namespaces. Do not call it outside",
+	Hidden: true,
+	Action: func(context *cli.Context) error {
+		return container.RunPauseProcess(context.Args().Get(0))
+	},
+}
+
 var initCommand = cli.Command{
 	Name:  "init",
 	Usage: "Init container process run user's process in container. Do not call it outside",
@@ -134,15 +477,69 @@ var listCommand = cli.Command{
 	},
 }
 
+// 命令格式为：mydocker logs [-f] [--tail N] [--since 时间] [--until 时间] [-t] [--stdout] [--stderr] 容器名
 var logCommand = cli.Command{
 	Name:  "logs",
 	Usage: "print logs of a container",
+	Flags: []cli.Flag{
+		cli.BoolFlag{
+			Name:  "follow, f",
+			Usage: "follow log output",
+		},
+		cli.IntFlag{
+			Name:  "tail",
+			Usage: "number of lines to show from the end of the logs, -1 means all",
+			Value: -1,
+		},
+		cli.StringFlag{
+			Name:  "since",
+			Usage: "show logs since timestamp (e.g. 2019-12-20T10:00:00Z) or relative (e.g. 10m)",
+		},
+		cli.StringFlag{
+			Name:  "until",
+			Usage: "show logs before timestamp (e.g. 2019-12-20T10:00:00Z) or relative (e.g. 10m)",
+		},
+		cli.BoolFlag{
+			Name:  "timestamps, t",
+			Usage: "show timestamps",
+		},
+		cli.BoolFlag{
+			Name:  "stdout",
+			Usage: "only show stdout logs",
+		},
+		cli.BoolFlag{
+			Name:  "stderr",
+			Usage: "only show stderr logs",
+		},
+	},
 	Action: func(context *cli.Context) error {
 		if len(context.Args()) < 1 {
 			return fmt.Errorf("logCommand: please input container name...")
 		}
 		containerName := context.Args().Get(0)
-		logContainer(containerName)
+		now := time.Now()
+		since, err := logger.ParseTime(context.String("since"), now)
+		if err != nil {
+			return err
+		}
+		until, err := logger.ParseTime(context.String("until"), now)
+		if err != nil {
+			return err
+		}
+		config := &logger.ReadConfig{
+			Tail:   context.Int("tail"),
+			Since:  since,
+			Until:  until,
+			Stdout: context.Bool("stdout"),
+			Stderr: context.Bool("stderr"),
+			Follow: context.Bool("follow"),
+		}
+		// 都没有指定时，两个输出流都显示
+		if !config.Stdout && !config.Stderr {
+			config.Stdout = true
+			config.Stderr = true
+		}
+		logContainer(containerName, config, context.Bool("timestamps"))
 		return nil
 	},
 }
@@ -175,25 +572,106 @@ var removeCommand = cli.Command{
 	},
 }
 
-// 命令格式为：mydocker exec 容器名 命令
+// 命令格式为：mydocker exec [-it] [-d] [-e KEY=VALUE] [-w 目录] [-u uid[:gid]] 容器名 命令
 var execCommand = cli.Command{
 	Name:  "exec",
 	Usage: "exec a command into coontainer",
+	// 容器名之后的参数都属于要执行的命令，不能再当作 mydocker 的参数解析
+	SkipArgReorder: true,
+	Flags: []cli.Flag{
+		cli.BoolFlag{
+			Name:  "it",
+			Usage: "enable tty",
+		},
+		cli.BoolFlag{
+			Name:  "d",
+			Usage: "run command in background, its session record and log are kept until the container is removed",
+		},
+		cli.StringSliceFlag{
+			Name:  "e",
+			Usage: "set environment",
+		},
+		cli.StringFlag{
+			Name:  "w",
+			Usage: "working directory inside the container",
+		},
+		cli.StringFlag{
+			Name:  "u",
+			Usage: "user, format: uid[:gid]",
+		},
+		cli.StringSliceFlag{
+			Name:  "cap-add",
+			Usage: "add linux capabilities to the exec process",
+		},
+		cli.StringSliceFlag{
+			Name:  "cap-drop",
+			Usage: "drop linux capabilities from the exec process",
+		},
+		cli.BoolFlag{
+			Name:  "privileged",
+			Usage: "give all capabilities to the exec process",
+		},
+	},
 	Action: func(context *cli.Context) error {
 		if os.Getenv(ENV_EXEC_PID) != "" {
 			logrus.Infof("execCommand: pid callback, pid is: %v", os.Getpid())
-			return nil
+			return container.RunContainerExecProcess()
 		}
 		if len(context.Args()) < 2 {
 			return fmt.Errorf("execCommand: missing container name or command")
 		}
+		tty := context.Bool("it")
+		detach := context.Bool("d")
+		if tty && detach {
+			return fmt.Errorf("it and d parameter can not both provided.")
+		}
 		containerName := context.Args().Get(0)
 		var commandArray []string
 		for _, arg := range context.Args().Tail() {
 			commandArray = append(commandArray, arg)
 		}
-		// 执行命令
-		ExecContainer(containerName, commandArray)
+		// 执行命令，与 run 一样以用户命令的退出码退出
+		exitCode := ExecContainer(containerName, commandArray, tty, detach, context.StringSlice("e"),
+			context.String("w"), context.String("u"), context.StringSlice("cap-add"), context.StringSlice("cap-drop"),
+			context.Bool("privileged"))
+		if exitCode != 0 {
+			os.Exit(exitCode)
+		}
+		return nil
+	},
+}
+
+// 命令格式为：mydocker attach [--detach-keys ctrl-p,ctrl-q] 容器名
+var attachCommand = cli.Command{
+	Name:  "attach",
+	Usage: "attach to a running detached container",
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:  "detach-keys",
+			Usage: "key sequence for detaching from the container",
+			Value: defaultDetachKeys,
+		},
+	},
+	Action: func(context *cli.Context) error {
+		if len(context.Args()) < 1 {
+			return fmt.Errorf("Missing container name")
+		}
+		containerName := context.Args().Get(0)
+		attachContainer(containerName, context.String("detach-keys"))
+		return nil
+	},
+}
+
+// 命令格式为：mydocker inspect 容器名
+var inspectCommand = cli.Command{
+	Name:  "inspect",
+	Usage: "display detailed information of a container",
+	Action: func(context *cli.Context) error {
+		if len(context.Args()) < 1 {
+			return fmt.Errorf("Missing container name")
+		}
+		containerName := context.Args().Get(0)
+		inspectContainer(containerName)
 		return nil
 	},
 }
@@ -202,6 +680,13 @@ var execCommand = cli.Command{
 var networkCommand = cli.Command{
 	Name:  "network",
 	Usage: "container network commands",
+	// 创建网桥、配置 iptables 都需要 root 权限，rootless 模式下容器只有 lo 网卡
+	Before: func(context *cli.Context) error {
+		if container.IsRootless() {
+			return fmt.Errorf("network commands require root, containers only have a loopback network in rootless mode")
+		}
+		return nil
+	},
 	Subcommands: []cli.Command{
 		{
 			Name:  "create",
@@ -259,3 +744,106 @@ var networkCommand = cli.Command{
 		},
 	},
 }
+
+// 用法：mydocker pod create [--hostname 主机名] [--net 网络] [-p 端口映射] [-m 内存] pod名，之后用 mydocker run --pod pod名 在 pod 中运行容器
+var podCommand = cli.Command{
+	Name:  "pod",
+	Usage: "manage pods, groups of containers sharing network, ipc and uts namespaces and a parent cgroup",
+	// infra 进程的网络和 pod 的父 cgroup 都需要 root 权限
+	Before: func(context *cli.Context) error {
+		if container.IsRootless() {
+			return fmt.Errorf("pod commands require root")
+		}
+		return nil
+	},
+	Subcommands: []cli.Command{
+		{
+			Name:  "create",
+			Usage: "create a pod",
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "hostname",
+					Usage: "host name shared by the containers in the pod, default is the pod name",
+				},
+				cli.StringFlag{
+					Name:  "net",
+					Usage: "network the pod connects to",
+				},
+				cli.StringSliceFlag{
+					Name:  "p",
+					Usage: "port mapping",
+				},
+				cli.StringFlag{
+					Name:  "m",
+					Usage: "memory limit of the pod",
+				},
+				cli.StringFlag{
+					Name:  "cpushare",
+					Usage: "cpushare limit of the pod",
+				},
+				cli.StringFlag{
+					Name:  "cpuset",
+					Usage: "cpuset limit of the pod",
+				},
+				cli.StringFlag{
+					Name:  "pids-limit",
+					Usage: "maximum number of processes in the pod, -1 for unlimited",
+				},
+			},
+			Action: func(context *cli.Context) error {
+				if len(context.Args()) < 1 {
+					return fmt.Errorf("Missing pod name")
+				}
+				pidsLimit, err := parsePidsLimit(context.String("pids-limit"))
+				if err != nil {
+					return err
+				}
+				if context.String("net") == "" && len(context.StringSlice("p")) > 0 {
+					return fmt.Errorf("port mapping requires --net")
+				}
+				res := &subsystem.ResourceConfig{
+					MemoryLimit: context.String("m"),
+					CpuShare:    context.String("cpushare"),
+					CpuSet:      context.String("cpuset"),
+					PidsLimit:   pidsLimit,
+				}
+				return createPod(context.Args().Get(0), context.String("hostname"), context.String("net"), context.StringSlice("p"), res)
+			},
+		},
+		{
+			Name:  "rm",
+			Usage: "remove a pod",
+			Flags: []cli.Flag{
+				cli.BoolFlag{
+					Name:  "f",
+					Usage: "stop and remove the containers in the pod",
+				},
+			},
+			Action: func(context *cli.Context) error {
+				if len(context.Args()) < 1 {
+					return fmt.Errorf("Missing pod name")
+				}
+				return removePod(context.Args().Get(0), context.Bool("f"))
+			},
+		},
+		{
+			Name:  "ps",
+			Usage: "list all pods",
+			Action: func(context *cli.Context) error {
+				listPods()
+				return nil
+			},
+		},
+		{
+			Name:  "inspect",
+			Usage: "show the details of a pod",
+			Action: func(context *cli.Context) error {
+				if len(context.Args()) < 1 {
+					return fmt.Errorf("Missing pod name")
+				}
+				inspectPod(context.Args().Get(0))
+				return nil
+			},
+		},
+	},
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestExpandShortFlags(t *testing.T) {
+	tests := map[string]string{
+		"mydocker run -dit busybox sh":              "mydocker run -d -i -t busybox sh",
+		"mydocker run -it busybox sh":               "mydocker run -i -t busybox sh",
+		"mydocker run -ti busybox sh":               "mydocker run -t -i busybox sh",
+		"mydocker run -d -i -t busybox sh":          "mydocker run -d -i -t busybox sh",
+		"mydocker run -e X -it busybox sh":          "mydocker run -e X -i -t busybox sh",
+		"mydocker run -e -it busybox sh":            "mydocker run -e -it busybox sh",
+		"mydocker run --name=web -di busybox top":   "mydocker run --name=web -d -i busybox top",
+		"mydocker run --name web --init -it bb top": "mydocker run --name web --init -i -t bb top",
+		"mydocker run -it busybox ls -dit":          "mydocker run -i -t busybox ls -dit",
+		"mydocker run -xy busybox sh":               "mydocker run -xy busybox sh",
+		"mydocker exec -it web sh":                  "mydocker exec -it web sh",
+		"mydocker":                                  "mydocker",
+	}
+	for args, expected := range tests {
+		got := expandShortFlags(strings.Fields(args))
+		if want := strings.Fields(expected); !reflect.DeepEqual(got, want) {
+			t.Errorf("expandShortFlags(%q): expected %q, got %q", args, want, got)
+		}
+	}
+}
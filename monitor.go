@@ -0,0 +1,295 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"github.com/Sirupsen/logrus"
+	"github.com/kkBill/mydocker/container"
+	"github.com/kkBill/mydocker/logger"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/exec"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// 前台 run 进程通过这个环境变量把容器Id传给 monitor 进程，同时也用它判断当前进程是不是 monitor
+const ENV_MONITOR_ID = "mydocker_monitor_id"
+
+// monitor 进程启动容器成功后，通过同步管道返回给前台 run 进程的内容
+const monitorReady = "ok"
+
+// 后台运行容器的过程如下：
+// 1.前台的 run 进程以相同的参数重新执行自己，并通过环境变量 mydocker_monitor_id 告诉子进程它是 monitor
+// 2.monitor 进程脱离当前终端的会话，启动容器，并一直持有容器的标准输入输出，直到容器退出
+// 3.前台 run 进程通过同步管道等待 monitor 启动好容器后退出
+func startMonitor(containerID, containerName string) error {
+	readPipe, writePipe, err := container.NewPipe()
+	if err != nil {
+		return fmt.Errorf("new pipe error %v", err)
+	}
+	defer readPipe.Close()
+
+	// monitor 进程的日志写到容器信息目录的 monitor.log 中
+	dirURL := fmt.Sprintf(container.DefaultInfoLocation, containerName)
+	if err := os.MkdirAll(dirURL, 0700); err != nil {
+		return fmt.Errorf("mkdir %s error %v", dirURL, err)
+	}
+	monitorLogPath := dirURL + container.MonitorLogFile
+	monitorLog, err := os.Create(monitorLogPath)
+	if err != nil {
+		return fmt.Errorf("create %s error %v", monitorLogPath, err)
+	}
+	defer monitorLog.Close()
+
+	cmd := exec.Command("/proc/self/exe", os.Args[1:]...)
+	cmd.Env = append(os.Environ(), ENV_MONITOR_ID+"="+containerID)
+	cmd.Stdout = monitorLog
+	cmd.Stderr = monitorLog
+	cmd.ExtraFiles = []*os.File{writePipe}
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+	if err := cmd.Start(); err != nil {
+		writePipe.Close()
+		return fmt.Errorf("start monitor error %v", err)
+	}
+	writePipe.Close()
+
+	msg, err := ioutil.ReadAll(readPipe)
+	if err != nil || string(msg) != monitorReady {
+		return fmt.Errorf("start container %s failed, see %s for details", containerName, monitorLogPath)
+	}
+	fmt.Println(containerID)
+	return nil
+}
+
+// monitor 进程中的 fd 3 是与前台 run 进程同步用的管道，不能被之后启动的子进程继承
+func setupMonitor() {
+	syscall.CloseOnExec(3)
+}
+
+// 通知前台 run 进程容器已经启动
+func notifyMonitorReady() {
+	syncPipe := os.NewFile(uintptr(3), "sync")
+	if _, err := syncPipe.WriteString(monitorReady); err != nil {
+		logrus.Errorf("notify run process error %v", err)
+	}
+	syncPipe.Close()
+}
+
+// monitor 进程的主体：
+// 1.把容器的输出写入日志文件，并转发给所有 attach 上来的客户端
+// 2.在 attach.sock 上监听客户端的连接，把客户端的输入转发给容器
+// 3.等待容器退出，返回 Wait 的结果，由 Run 记录容器的退出状态
+func monitorContainer(containerInfo *container.ContainerInfo, parent *exec.Cmd, pio *container.ProcessIO) error {
+	containerName := containerInfo.Name
+	dirURL := fmt.Sprintf(container.DefaultInfoLocation, containerName)
+
+	logWriter, err := newLogDriver(containerInfo)
+	if err != nil {
+		logrus.Errorf("monitorContainer: create log driver %s error %v.", containerInfo.LogDriver, err)
+		return killContainer(parent)
+	}
+	defer logWriter.Close()
+
+	hub := newIOHub(pio)
+	hub.pump(logWriter)
+
+	socketPath := dirURL + container.AttachSocket
+	os.Remove(socketPath)
+	listener, err := net.Listen("unix", socketPath)
+	if err != nil {
+		logrus.Errorf("monitorContainer: listen %s error %v.", socketPath, err)
+		return killContainer(parent)
+	}
+	defer os.Remove(socketPath)
+	defer listener.Close()
+	go hub.serve(listener)
+
+	notifyMonitorReady()
+
+	err = parent.Wait()
+	if err != nil {
+		logrus.Infof("container %s exit: %v", containerName, err)
+	}
+	hub.close()
+	return err
+}
+
+// monitor 无法接管容器的输入输出时杀掉容器，避免留下没人管的容器进程
+func killContainer(parent *exec.Cmd) error {
+	parent.Process.Kill()
+	return parent.Wait()
+}
+
+// attach 客户端发给 monitor 的数据帧：1 字节类型 + 4 字节长度 + 数据
+const (
+	frameStdin  byte = 0 // 标准输入
+	frameResize byte = 1 // 终端窗口大小，数据为 rows、cols 两个 uint16
+)
+
+func writeFrame(w io.Writer, frameType byte, payload []byte) error {
+	header := make([]byte, 5)
+	header[0] = frameType
+	binary.BigEndian.PutUint32(header[1:], uint32(len(payload)))
+	if _, err := w.Write(append(header, payload...)); err != nil {
+		return err
+	}
+	return nil
+}
+
+func readFrame(r io.Reader) (byte, []byte, error) {
+	header := make([]byte, 5)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return 0, nil, err
+	}
+	payload := make([]byte, binary.BigEndian.Uint32(header[1:]))
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return 0, nil, err
+	}
+	return header[0], payload, nil
+}
+
+// 每个 attach 客户端最多缓存的输出块数，超过时说明客户端跟不上容器的输出，直接断开
+const clientQueueSize = 256
+
+// 向 attach 客户端写输出的超时时间，避免容器退出时被停住的客户端卡住 monitor
+const clientWriteTimeout = 5 * time.Second
+
+// 容器标准输入输出的集线器：把容器的输出广播给所有 attach 的客户端，把客户端的输入转发给容器
+type ioHub struct {
+	pio     *container.ProcessIO
+	mu      sync.Mutex
+	clients map[net.Conn]chan []byte
+	// 等待容器的输出全部转发完
+	outputWg sync.WaitGroup
+	// 等待各个客户端的输出队列写完
+	clientWg sync.WaitGroup
+}
+
+func newIOHub(pio *container.ProcessIO) *ioHub {
+	return &ioHub{
+		pio:     pio,
+		clients: map[net.Conn]chan []byte{},
+	}
+}
+
+// 开始转发容器的输出，同时按输出流写入日志，tty 模式下 pty 的输出都记为标准输出
+func (h *ioHub) pump(logWriter logger.LogDriver) {
+	copyOutput := func(dst io.Writer, src io.Reader) {
+		h.outputWg.Add(1)
+		go func() {
+			defer h.outputWg.Done()
+			io.Copy(dst, src)
+		}()
+	}
+	if h.pio.Console != nil {
+		copyOutput(io.MultiWriter(logWriter.StreamWriter(logger.Stdout), h), h.pio.Console)
+		return
+	}
+	copyOutput(io.MultiWriter(logWriter.StreamWriter(logger.Stdout), h), h.pio.Stdout)
+	copyOutput(io.MultiWriter(logWriter.StreamWriter(logger.Stderr), h), h.pio.Stderr)
+}
+
+// 把容器的输出放进每个客户端的输出队列，不在这里等待客户端，队列满了的客户端直接断开
+func (h *ioHub) Write(p []byte) (int, error) {
+	data := make([]byte, len(p))
+	copy(data, p)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for conn, queue := range h.clients {
+		select {
+		case queue <- data:
+		default:
+			logrus.Warnf("attach client is too slow, disconnecting it")
+			h.removeClientLocked(conn)
+		}
+	}
+	return len(p), nil
+}
+
+func (h *ioHub) serve(listener net.Listener) {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		queue := make(chan []byte, clientQueueSize)
+		h.mu.Lock()
+		h.clients[conn] = queue
+		h.mu.Unlock()
+		h.clientWg.Add(1)
+		go h.writeClient(conn, queue)
+		go h.handleClient(conn)
+	}
+}
+
+// 把输出队列中的内容写给客户端，队列关闭并写完后断开连接
+func (h *ioHub) writeClient(conn net.Conn, queue chan []byte) {
+	defer h.clientWg.Done()
+	defer conn.Close()
+	for data := range queue {
+		conn.SetWriteDeadline(time.Now().Add(clientWriteTimeout))
+		if _, err := conn.Write(data); err != nil {
+			// 关闭连接后 handleClient 读取失败，会把客户端移除并关闭队列
+			conn.Close()
+		}
+	}
+}
+
+// 调用者需要持有 h.mu
+func (h *ioHub) removeClientLocked(conn net.Conn) {
+	if queue, ok := h.clients[conn]; ok {
+		delete(h.clients, conn)
+		close(queue)
+	}
+}
+
+// 读取客户端发来的数据帧，直到客户端断开
+func (h *ioHub) handleClient(conn net.Conn) {
+	defer func() {
+		h.mu.Lock()
+		h.removeClientLocked(conn)
+		h.mu.Unlock()
+	}()
+	for {
+		frameType, payload, err := readFrame(conn)
+		if err != nil {
+			return
+		}
+		switch frameType {
+		case frameStdin:
+			// 只有 -i 时容器才有标准输入
+			if h.pio.Stdin == nil {
+				continue
+			}
+			if _, err := h.pio.Stdin.Write(payload); err != nil {
+				logrus.Errorf("write container stdin error %v", err)
+			}
+		case frameResize:
+			if h.pio.Console == nil || len(payload) != 4 {
+				continue
+			}
+			ws := &container.Winsize{
+				Rows: binary.BigEndian.Uint16(payload[0:2]),
+				Cols: binary.BigEndian.Uint16(payload[2:4]),
+			}
+			if err := container.SetWinsize(h.pio.Console.Fd(), ws); err != nil {
+				logrus.Errorf("set pty size error %v", err)
+			}
+		}
+	}
+}
+
+// 等待容器的输出全部转发完，然后等客户端把各自队列中的输出写完后断开
+func (h *ioHub) close() {
+	h.outputWg.Wait()
+	h.mu.Lock()
+	for conn := range h.clients {
+		h.removeClientLocked(conn)
+	}
+	h.mu.Unlock()
+	h.clientWg.Wait()
+}
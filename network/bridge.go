@@ -119,7 +119,7 @@ func createBridgeInterface(bridgeName string) error {
 	la.Name = bridgeName
 
 	// 使用 link 对象创建 netlink 的bridge 对象
-	br := &netlink.Bridge{la}
+	br := &netlink.Bridge{LinkAttrs: la}
 	//
 	if err := netlink.LinkAdd(br); err != nil {
 		return fmt.Errorf("Bridge creation failed for bridge %s: %v", bridgeName, err)
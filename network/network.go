@@ -141,6 +141,11 @@ func (network *Network) load(dumpPath string) error {
 
 // 创建容器并连接网络
 func Connect(networkName string, cinfo *container.ContainerInfo) error {
+	// rootless 模式下不能创建 veth 和网桥，退化为只有 lo 的网络（lo 由容器 init 进程启用）
+	if container.IsRootless() {
+		logrus.Warnf("Connect: rootless mode can not create bridge network %s, falling back to a loopback-only network", networkName)
+		return nil
+	}
 	// 从networks字典中取到容器连接的网络信息，networks 保存了当前已经创建的网络
 	network, ok := networks[networkName]
 	logrus.Infof("network.IpRange: %v", network.IpRange) // 这里是正确的！
@@ -155,6 +160,7 @@ func Connect(networkName string, cinfo *container.ContainerInfo) error {
 		return err
 	}
 	logrus.Infof("Connect: ip: %v",ip.To4()) // 这里的 ip 是空的，问题一定出在 Allocate()
+	cinfo.IPAddress = ip.String()
 
 	// 创建网络端点
 	endpoint := &Endpoint{
@@ -309,6 +315,10 @@ func configPortMapping(endpoint *Endpoint, cinfo *container.ContainerInfo) error
 
 // 初始化网络（也就是把网络配置信息从文件中读取到内存相应的数据结构中以供调用）
 func Init() error {
+	// rootless 模式下没有权限创建网桥，也就不需要加载网络配置
+	if os.Geteuid() != 0 {
+		return nil
+	}
 	// 加载网络驱动
 	bridgeDriver := BridgeNetworkDriver{}
 	drivers[bridgeDriver.Name()] = &bridgeDriver
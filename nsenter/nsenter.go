@@ -5,11 +5,95 @@ package nsenter
 #include <unistd.h>
 #include <errno.h>
 #include <sched.h>
+#include <signal.h>
 #include <stdio.h>
 #include <stdlib.h>
 #include <string.h>
 #include <fcntl.h>
-__attribute__((constructor)) void enter_namespace(void) {
+#include <sys/stat.h>
+#include <sys/types.h>
+#include <sys/wait.h>
+
+#ifndef CLONE_NEWTIME
+#define CLONE_NEWTIME 0x00000080
+#endif
+
+static pid_t child_pid;
+
+// 把 exec 进程收到的信号转发给真正在容器内执行命令的子进程
+static void forward_signal(int sig) {
+	if (child_pid > 0) {
+		kill(child_pid, sig);
+	}
+}
+
+// 容器 init 进程由 newuidmap/newgidmap 写入 id 映射时，exec 的时候映射还不存在，进程没有任何 capability
+// 这里先从 fd 3 的管道中读取一个字节，等待父进程写好映射，然后重新 exec 自己，以映射后的 root 身份获得 capability
+static void wait_userns_mapping(char **argv) {
+	char c;
+	while (read(3, &c, 1) == -1) {
+		if (errno != EINTR) {
+			fprintf(stderr, "wait for userns mapping failed: %s\n", strerror(errno));
+			exit(1);
+		}
+	}
+	unsetenv("mydocker_userns_sync");
+	execv("/proc/self/exe", argv);
+	fprintf(stderr, "reexec after userns mapping failed: %s\n", strerror(errno));
+	exit(1);
+}
+
+// exec 进程在 fork 之前先从 fd 3 的管道中读取一个字节，等待父进程把自己加入容器的 cgroup，
+// 这样 fork 出来的子进程也在容器的 cgroup 中，受到容器资源限制的约束
+static void wait_exec_cgroup(void) {
+	char c;
+	while (read(3, &c, 1) == -1) {
+		if (errno != EINTR) {
+			fprintf(stderr, "wait for exec cgroup failed: %s\n", strerror(errno));
+			exit(1);
+		}
+	}
+}
+
+// 容器 init 进程创建 time namespace 并写入时钟偏移，之后 exec 的容器进程进入这个 namespace
+// 偏移必须在有进程进入 namespace 之前写入，/proc/self/timens_offsets 对应的是主线程，所以要在 go 运行时启动之前完成
+static void setup_time_namespace(const char *offsets) {
+	if (unshare(CLONE_NEWTIME) == -1) {
+		fprintf(stderr, "unshare time namespace failed: %s\n", strerror(errno));
+		exit(1);
+	}
+	int fd = open("/proc/self/timens_offsets", O_WRONLY);
+	if (fd == -1) {
+		fprintf(stderr, "open timens_offsets failed: %s\n", strerror(errno));
+		exit(1);
+	}
+	if (write(fd, offsets, strlen(offsets)) == -1) {
+		fprintf(stderr, "write timens_offsets \"%s\" failed: %s\n", offsets, strerror(errno));
+		exit(1);
+	}
+	close(fd);
+}
+
+// 与当前进程相同的 namespace 不需要进入，例如 --cgroupns=host 时，容器 user namespace 中的进程没有权限 setns 到宿主机的 cgroup namespace
+static int same_namespace(int fd, const char *ns) {
+	char path[64];
+	struct stat target, self;
+	snprintf(path, sizeof(path), "/proc/self/ns/%s", ns);
+	if (fstat(fd, &target) == -1 || stat(path, &self) == -1) {
+		return 0;
+	}
+	return target.st_dev == self.st_dev && target.st_ino == self.st_ino;
+}
+
+__attribute__((constructor)) void enter_namespace(int argc, char **argv) {
+	if (getenv("mydocker_userns_sync")) {
+		wait_userns_mapping(argv);
+	}
+	char *time_offsets = getenv("mydocker_time_offsets");
+	if (time_offsets) {
+		setup_time_namespace(time_offsets);
+	}
+
 	char *mydocker_pid;
 	mydocker_pid = getenv("mydocker_pid");
 	if (mydocker_pid) {
@@ -18,30 +102,64 @@ __attribute__((constructor)) void enter_namespace(void) {
 		//fprintf(stdout, "missing mydocker_pid env skip nsenter");
 		return;
 	}
-	char *mydocker_cmd;
-	mydocker_cmd = getenv("mydocker_cmd");
-	if (mydocker_cmd) {
-		//fprintf(stdout, "got mydocker_cmd=%s\n", mydocker_cmd);
-	} else {
-		//fprintf(stdout, "missing mydocker_cmd env skip nsenter");
-		return;
-	}
+	wait_exec_cgroup();
 	int i;
 	char nspath[1024];
-	char *namespaces[] = { "ipc", "uts", "net", "pid", "mnt" };
-	for (i=0; i<5; i++) {
-		sprintf(nspath, "/proc/%s/ns/%s", mydocker_pid, namespaces[i]);
-		int fd = open(nspath, O_RDONLY);
-		if (setns(fd, 0) == -1) {
-			//fprintf(stderr, "setns on %s namespace failed: %s\n", namespaces[i], strerror(errno));
-		} else {
-			//fprintf(stdout, "setns on %s namespace succeeded\n", namespaces[i]);
+	// 容器使用了 --userns-remap 时需要先进入它的 user namespace，这样 exec 进程的 id 才能与容器内一致
+	// 进入 time namespace 要求进程是单线程的，这里还没有启动 go 运行时，正好满足
+	char *namespaces[] = { "user", "ipc", "uts", "net", "pid", "cgroup", "time", "mnt" };
+	int fds[8];
+	int start = getenv("mydocker_join_userns") ? 0 : 1;
+	// 先打开所有 namespace 文件，再依次 setns，避免进入 mnt namespace 之后 /proc 路径发生变化
+	// 较老的内核没有 cgroup、time namespace，跳过不存在的 namespace 文件
+	for (i=start; i<8; i++) {
+		snprintf(nspath, sizeof(nspath), "/proc/%s/ns/%s", mydocker_pid, namespaces[i]);
+		fds[i] = open(nspath, O_RDONLY);
+		if (fds[i] == -1 && errno != ENOENT) {
+			fprintf(stderr, "open %s failed: %s\n", nspath, strerror(errno));
+			exit(1);
+		}
+	}
+	for (i=start; i<8; i++) {
+		if (fds[i] == -1) {
+			continue;
+		}
+		if (same_namespace(fds[i], namespaces[i])) {
+			close(fds[i]);
+			continue;
 		}
-		close(fd);
+		if (setns(fds[i], 0) == -1) {
+			fprintf(stderr, "setns on %s namespace failed: %s\n", namespaces[i], strerror(errno));
+			exit(1);
+		}
+		close(fds[i]);
+	}
+
+	// setns 进入 pid namespace 只对之后创建的子进程生效，所以这里需要 fork 一次
+	// 子进程回到 go 的运行时中完成剩下的设置，父进程负责等待子进程并返回它的退出码
+	child_pid = fork();
+	if (child_pid == -1) {
+		fprintf(stderr, "fork failed: %s\n", strerror(errno));
+		exit(1);
+	}
+	if (child_pid == 0) {
+		return;
+	}
+
+	int sigs[] = { SIGINT, SIGTERM, SIGHUP, SIGQUIT, SIGUSR1, SIGUSR2 };
+	for (i=0; i<6; i++) {
+		signal(sigs[i], forward_signal);
+	}
+	int status;
+	while (waitpid(child_pid, &status, 0) == -1) {
+		if (errno != EINTR) {
+			exit(1);
+		}
+	}
+	if (WIFSIGNALED(status)) {
+		exit(128 + WTERMSIG(status));
 	}
-	int res = system(mydocker_cmd);
-	exit(0);
-	return;
+	exit(WEXITSTATUS(status));
 }
  */
-import "C"
\ No newline at end of file
+import "C"
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/kkBill/mydocker/cgroup"
+	"github.com/kkBill/mydocker/cgroup/subsystem"
+	"github.com/kkBill/mydocker/container"
+	"github.com/kkBill/mydocker/network"
+)
+
+// pod inspect 输出的内容：pod 信息以及 pod 中的容器
+type podInspectInfo struct {
+	*container.PodInfo
+	Containers []string `json:"containers"`
+}
+
+// 创建 pod：
+// 1.启动 infra 进程，创建 pod 共享的 network、ipc、uts namespace
+// 2.创建 pod 的父 cgroup 并设置资源限制，pod 中容器的 cgroup 都在它下面
+// 3.把 infra 进程连接到网络，pod 中的容器共享这个网络，不再单独连接
+func createPod(podName, hostname, nw string, portMapping []string, res *subsystem.ResourceConfig) error {
+	if _, err := container.GetPodInfo(podName); err == nil {
+		return fmt.Errorf("pod %s already exists", podName)
+	}
+	podID := generateRandomID(10)
+	if hostname == "" {
+		hostname = podName
+	}
+
+	cmd := container.NewPauseProcess(hostname)
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("start pod infra process error %v", err)
+	}
+	infraPid := cmd.Process.Pid
+	podInfo := &container.PodInfo{
+		Id:          podID,
+		Name:        podName,
+		InfraPid:    strconv.Itoa(infraPid),
+		Hostname:    hostname,
+		Network:     nw,
+		PortMapping: portMapping,
+		CgroupPath:  container.PodCgroupPath(podName),
+		CreatedTime: time.Now().Format("2006-01-02 15:04:05"),
+		Status:      container.RUNNING,
+	}
+	fail := func(err error) error {
+		_ = syscall.Kill(infraPid, syscall.SIGTERM)
+		_, _ = cmd.Process.Wait()
+		_ = removeCgroupDirs(podInfo.CgroupPath)
+		return err
+	}
+
+	cgroupManager := cgroup.NewCgroupManager(podInfo.CgroupPath)
+	_ = cgroupManager.Set(res)
+	_ = cgroupManager.Apply(infraPid)
+
+	if nw != "" {
+		network.Init()
+		cinfo := &container.ContainerInfo{Id: podID, Name: podName, Pid: podInfo.InfraPid, PortMapping: portMapping}
+		if err := network.Connect(nw, cinfo); err != nil {
+			return fail(fmt.Errorf("connect pod %s to network %s error %v", podName, nw, err))
+		}
+		podInfo.IPAddress = cinfo.IPAddress
+	}
+	if err := container.RecordPodInfo(podInfo); err != nil {
+		return fail(fmt.Errorf("record pod %s info error %v", podName, err))
+	}
+	// infra 进程由 init 进程接管，这里不等待它退出
+	_ = cmd.Process.Release()
+	fmt.Println(podID)
+	return nil
+}
+
+// 删除 pod，pod 中还有容器时需要 -f 先停止并删除这些容器
+func removePod(podName string, force bool) error {
+	podInfo, err := container.GetPodInfo(podName)
+	if err != nil {
+		return err
+	}
+	containers := podContainers(podName)
+	if len(containers) > 0 && !force {
+		return fmt.Errorf("pod %s has %d containers, remove them first or use -f", podName, len(containers))
+	}
+	for _, c := range containers {
+		killPodContainer(c)
+	}
+
+	if podInfo.Status == container.RUNNING {
+		pid, _ := strconv.Atoi(podInfo.InfraPid)
+		if err := syscall.Kill(pid, syscall.SIGTERM); err != nil {
+			return fmt.Errorf("stop pod %s infra process error %v", podName, err)
+		}
+	}
+	if err := removePodCgroup(podInfo.CgroupPath); err != nil {
+		logrus.Warnf("remove pod %s cgroup %s error %v", podName, podInfo.CgroupPath, err)
+	}
+	return container.RemovePodInfo(podName)
+}
+
+// 强制删除 pod 中的容器：SIGKILL 容器的 init 进程，等 monitor 进程把容器状态更新为退出之后再删除
+func killPodContainer(c *container.ContainerInfo) {
+	if c.Status == container.RUNNING {
+		pid, _ := strconv.Atoi(c.Pid)
+		if err := syscall.Kill(pid, syscall.SIGKILL); err != nil {
+			logrus.Warnf("kill container %s error %v", c.Name, err)
+		}
+		for i := 0; i < 50; i++ {
+			if info, err := getContainerInfoByName(c.Name); err != nil || info.Status != container.RUNNING {
+				break
+			}
+			time.Sleep(100 * time.Millisecond)
+		}
+	}
+	removeContainer(c.Name)
+}
+
+// 容器的 cgroup 由各自的 run 或 monitor 进程在容器退出后删除，要等它们删除之后才能删除 pod 的 cgroup
+func removePodCgroup(cgroupPath string) error {
+	var err error
+	for i := 0; i < 50; i++ {
+		if err = removeCgroupDirs(cgroupPath); err == nil {
+			return nil
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	return err
+}
+
+// 删除各个 subsystem 中已经存在的 cgroup 目录，不存在的跳过
+func removeCgroupDirs(cgroupPath string) error {
+	for _, subSys := range subsystem.SubsystemsItems {
+		p, err := subsystem.GetCgroupPath(subSys.Name(), cgroupPath, false)
+		if err != nil {
+			continue
+		}
+		if err := os.Remove(p); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// 列出所有 pod
+func listPods() {
+	pods, err := container.ListPodInfos()
+	if err != nil {
+		logrus.Errorf("List pods error %v", err)
+		return
+	}
+	w := tabwriter.NewWriter(os.Stdout, 12, 1, 3, ' ', 0)
+	_, _ = fmt.Fprint(w, "ID\tNAME\tINFRA PID\tSTATUS\tIP\tCONTAINERS\tCREATED\n")
+	for _, pod := range pods {
+		var names []string
+		for _, c := range podContainers(pod.Name) {
+			names = append(names, c.Name)
+		}
+		_, _ = fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+			pod.Id,
+			pod.Name,
+			pod.InfraPid,
+			pod.Status,
+			pod.IPAddress,
+			strings.Join(names, ","),
+			pod.CreatedTime)
+	}
+	if err := w.Flush(); err != nil {
+		logrus.Errorf("Flush error %v", err)
+	}
+}
+
+func inspectPod(podName string) {
+	podInfo, err := container.GetPodInfo(podName)
+	if err != nil {
+		logrus.Errorf("Get pod %s info error %v", podName, err)
+		return
+	}
+	info := &podInspectInfo{PodInfo: podInfo, Containers: []string{}}
+	for _, c := range podContainers(podName) {
+		info.Containers = append(info.Containers, c.Name)
+	}
+	bytes, err := json.MarshalIndent(info, "", "    ")
+	if err != nil {
+		logrus.Errorf("Json marshal %s error %v", podName, err)
+		return
+	}
+	fmt.Println(string(bytes))
+}
+
+// pod 中的所有容器，包括已经退出的
+func podContainers(podName string) []*container.ContainerInfo {
+	dirURL := fmt.Sprintf(container.DefaultInfoLocation, "")
+	files, err := ioutil.ReadDir(dirURL[:len(dirURL)-1])
+	if err != nil {
+		return nil
+	}
+	var containers []*container.ContainerInfo
+	for _, file := range files {
+		if file.Name() == "network" || file.Name() == container.PodDirName {
+			continue
+		}
+		info, err := getContainerInfo(file)
+		if err != nil || info.Pod != podName {
+			continue
+		}
+		containers = append(containers, info)
+	}
+	return containers
+}
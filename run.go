@@ -7,11 +7,19 @@ import (
 	"github.com/kkBill/mydocker/cgroup"
 	"github.com/kkBill/mydocker/cgroup/subsystem"
 	"github.com/kkBill/mydocker/container"
+	"github.com/kkBill/mydocker/landlock"
+	"github.com/kkBill/mydocker/logger"
 	"github.com/kkBill/mydocker/network"
+	"github.com/kkBill/mydocker/seccomp"
+	"io"
 	"math/rand"
 	"os"
+	"os/exec"
+	"os/signal"
+	"path"
 	"strconv"
 	"strings"
+	"syscall"
 	"time"
 )
 
@@ -77,77 +85,278 @@ func Run(tty bool, comArray []string, res *subsystem.ResourceConfig, volume, con
 */
 
 // version 3
-func Run(tty bool, comArray []string, res *subsystem.ResourceConfig, volume, containerName, imageName string,
-	nw string, portmapping []string) {
-	// generate container ID (random 10 bits number)
-	containerID := generateRandomID(10)
-	if containerName == "" {
-		containerName = containerID
-	}
+// containerInfo 中已经填好了容器的 Id、Name、Volume、PortMapping、Tty、Detach 等配置
+// 后台运行时，Run 是在 monitor 进程中执行的，monitor 进程会一直持有容器的标准输入输出直到容器退出
+// 返回值作为 mydocker run 的退出码：前台运行时为容器的退出码，启动容器失败时为 1
+func Run(containerInfo *container.ContainerInfo, comArray []string, res *subsystem.ResourceConfig, imageName, nw string) int {
+	containerName := containerInfo.Name
+	volume := containerInfo.Volume
 
-	parent, writePipe := container.NewParentProcess(tty, volume, containerName, imageName)
+	nsPaths, err := namespacePaths(containerInfo.Namespaces)
+	if err != nil {
+		logrus.Errorf("Run: %v", err)
+		return 1
+	}
+	parent, writePipe, pio := container.NewParentProcess(containerInfo.Tty, containerInfo.Interactive, volume, containerName, imageName, containerInfo.Userns, containerInfo.Namespaces, containerInfo.TimeOffsets)
 	if parent == nil {
 		logrus.Errorf("new parent process failed")
-		return
+		return 1
 	}
 
-	if err := parent.Start(); err != nil {
+	if err := container.StartInNamespaces(parent, nsPaths); err != nil {
 		logrus.Error(err)
+		return 1
+	}
+	pio.CloseAfterStart()
+
+	// 非 root 用户使用 --userns-remap 时，由 newuidmap/newgidmap 写入映射，写好之后通知容器进程继续
+	if containerInfo.Userns.NeedNewIDMap() {
+		userns := containerInfo.Userns
+		if err := container.WriteIDMappings(parent.Process.Pid, userns.UidMappings, userns.GidMappings); err != nil {
+			logrus.Errorf("Run: write id mappings error %v", err)
+			parent.Process.Kill()
+			parent.Wait()
+			return 1
+		}
+		if _, err := writePipe.Write([]byte{0}); err != nil {
+			logrus.Errorf("Run: notify userns mapping error %v", err)
+		}
 	}
 
 	// 记录容器信息
-	containerName, err := recordContainerInfo(parent.Process.Pid, comArray, containerName, containerID, volume)
-	if err != nil {
+	if err := recordContainerInfo(parent.Process.Pid, comArray, containerInfo); err != nil {
 		logrus.Errorf("record container info error %v", err)
-		return
+		return 1
 	}
 
-	// 资源限制 cgroup
-	cgroupManager := cgroup.NewCgroupManager("mydocker-cgroup")
+	// 资源限制 cgroup，每个容器单独一个 cgroup，--report 才能统计到这个容器自己的资源使用；pod 中的容器放在 pod 的 cgroup 下面
+	cgroupManager := cgroup.NewCgroupManager(containerCgroupPath(containerInfo))
 	defer cgroupManager.Remove()
-	_ = cgroupManager.Set(res)
+	if err := cgroupManager.Set(res); err != nil && !containerInfo.Privileged {
+		// 设备白名单没有生效时去掉 CAP_MKNOD，否则容器可以创建宿主机上任意设备的设备文件
+		logrus.Warnf("Run: %v, dropping CAP_MKNOD", err)
+		containerInfo.Capabilities, _ = container.ResolveCapabilities(containerInfo.Capabilities, nil, []string{"CAP_MKNOD"})
+	}
 	_ = cgroupManager.Apply(parent.Process.Pid)
+	if res.OomScoreAdj != 0 {
+		if err := container.SetOomScoreAdj(parent.Process.Pid, res.OomScoreAdj); err != nil {
+			logrus.Warnf("Run: %v", err)
+		}
+	}
 
 	//
 	if nw != "" {
 		// 配置容器网络
 		network.Init()
-		containerInfo := &container.ContainerInfo{
-			Pid:         strconv.Itoa(parent.Process.Pid),
-			Id:          containerID,
-			Name:        containerName,
-			PortMapping: portmapping,
-		}
-
 		if err := network.Connect(nw, containerInfo); err != nil {
 			logrus.Errorf("Run: error Connect Network %v", err)
-			return
+			return 1
+		}
+	}
+
+	// 生成容器的 hosts、resolv.conf、hostname 文件，hosts 中需要用到上面分配的 IP
+	if err := container.CreateEtcFiles(containerInfo); err != nil {
+		logrus.Errorf("Run: create etc files error %v", err)
+		writePipe.Close()
+		return 1
+	}
+	if err := updateContainerInfo(containerInfo); err != nil {
+		logrus.Errorf("update container %s info error %v", containerName, err)
+	}
+
+	// seccomp 配置在启动参数解析时已经检查过，这里保存一份给 exec 进程使用
+	seccompProfile, err := seccomp.LoadProfile(containerInfo.Seccomp)
+	if err == nil {
+		err = container.SaveSeccompProfile(containerName, seccompProfile)
+	}
+	if err != nil {
+		logrus.Errorf("Run: load seccomp profile %s error %v", containerInfo.Seccomp, err)
+		writePipe.Close()
+		return 1
+	}
+	var landlockPolicy *landlock.Policy
+	if containerInfo.Landlock != "" {
+		landlockPolicy, err = landlock.LoadPolicy(containerInfo.Landlock)
+		if err == nil {
+			err = container.SaveLandlockPolicy(containerName, landlockPolicy)
+		}
+		if err != nil {
+			logrus.Errorf("Run: load landlock policy error %v", err)
+			writePipe.Close()
+			return 1
 		}
 	}
 
 	// 父进程向子进程通过管道发送信息
-	sendInitCommand(comArray, writePipe)
+	cloneFlags := containerInfo.Namespaces.CloneFlags()
+	initConfig := &container.InitConfig{
+		Args:         comArray,
+		EtcDir:       fmt.Sprintf(container.DefaultInfoLocation, containerName),
+		Cwd:          containerInfo.WorkingDir,
+		User:         containerInfo.User,
+		Overlay:      container.RootlessOverlayOptions(imageName, containerName, containerInfo.Userns),
+		Capabilities: containerInfo.Capabilities,
+		AmbientCaps:  container.AmbientCapabilities(containerInfo.Capabilities, containerInfo.CapAdd),
+		Seccomp:      seccompProfile,
+		Landlock:     landlockPolicy,
+		Privileged:   containerInfo.Privileged,
+		ReadOnly:     containerInfo.ReadOnly,
+		Devices:      containerInfo.Devices,
+		ShmSize:      containerInfo.ShmSize,
+		Ulimits:      res.Ulimits,
+		Sysctls:      containerInfo.Sysctls,
+		CloneFlags:   cloneFlags,
+		Init:         containerInfo.Init,
+	}
+	// 共享 uts namespace 时不能修改主机名
+	if cloneFlags&syscall.CLONE_NEWUTS != 0 {
+		initConfig.Hostname = containerInfo.Hostname
+		initConfig.Domainname = containerInfo.Domainname
+	}
+	sendInitConfig(initConfig, writePipe)
 
-	// 只有在 -ti 交互模式下才需要等待子进程，否则就是后台运行模式，即父进程就直接退出
-	if tty {
-		parent.Wait()
-		deleteContainerInfo(containerName)
+	startTime := time.Now()
+	timeout := watchTimeout(parent.Process, containerInfo.Timeout)
+	var waitErr error
+	if containerInfo.Detach {
+		// 后台运行模式下，由 monitor 进程等待容器退出
+		waitErr = monitorContainer(containerInfo, parent, pio)
+	} else {
+		// 容器的输出在写到当前进程标准输出的同时也写入日志，容器退出后仍然可以通过 logs 命令查看
+		var stdout, stderr io.Writer = os.Stdout, os.Stderr
+		logWriter, err := newLogDriver(containerInfo)
+		if err != nil {
+			logrus.Errorf("Run: create log driver %s error %v, container output will not be logged", containerInfo.LogDriver, err)
+		} else {
+			stdout = io.MultiWriter(os.Stdout, logWriter.StreamWriter(logger.Stdout))
+			stderr = io.MultiWriter(os.Stderr, logWriter.StreamWriter(logger.Stderr))
+		}
+		terminal := attachHostTerminal(pio, stdout, stderr)
+		stopForward := forwardSignals(parent.Process)
+		waitErr = parent.Wait()
+		stopForward()
+		terminal.wait()
+		if logWriter != nil {
+			logWriter.Close()
+		}
+	}
+	exitCode := containerExitCode(waitErr)
+	// 保留容器信息和日志，由 rm 命令删除
+	recordContainerExit(containerInfo, cgroupManager, exitCode, timeout.stop(), startTime)
+	if !containerInfo.Detach {
 		container.DeleteWorkSpace(volume, containerName)
 	}
+	return exitCode
+}
+
+// 前台运行时转发给容器 init 进程的信号，与 exec 进程转发的信号相同
+var forwardedSignals = []os.Signal{syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP, syscall.SIGQUIT, syscall.SIGUSR1, syscall.SIGUSR2}
+
+// 把 mydocker run 收到的信号转发给容器的 init 进程，返回停止转发的函数
+func forwardSignals(process *os.Process) func() {
+	sigs := make(chan os.Signal, len(forwardedSignals))
+	signal.Notify(sigs, forwardedSignals...)
+	go func() {
+		for sig := range sigs {
+			if err := process.Signal(sig); err != nil && err != os.ErrProcessDone {
+				logrus.Warnf("forward signal %v to container error %v", sig, err)
+			}
+		}
+	}()
+	return func() {
+		signal.Stop(sigs)
+		close(sigs)
+	}
 }
 
-func sendInitCommand(comArray []string, writePipe *os.File) {
-	command := strings.Join(comArray, " ")
-	logrus.Infof("command: [%v]", command)
-	bytes, err := writePipe.WriteString(command)
-	logrus.Infof("sendInitCommand: write bytes %d", bytes)
+// 容器的 cgroup 路径，exec 进程也会加入这个 cgroup
+func containerCgroupPath(containerInfo *container.ContainerInfo) string {
+	if containerInfo.Pod != "" {
+		return path.Join(container.PodCgroupPath(containerInfo.Pod), containerInfo.Name)
+	}
+	return "mydocker-cgroup-" + containerInfo.Name
+}
+
+// 容器 init 进程的退出码，被信号杀死时为 128+信号
+func containerExitCode(err error) int {
+	if err == nil {
+		return 0
+	}
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		if status, ok := exitErr.Sys().(syscall.WaitStatus); ok {
+			return container.ExitCode(status)
+		}
+	}
+	logrus.Errorf("wait container error %v", err)
+	return 1
+}
+
+// 找到要加入 namespace 的容器的 init 进程或 pod 的 infra 进程，返回 namespace 名到 /proc/<pid>/ns/<name> 的映射
+func namespacePaths(namespaces *container.NamespaceConfig) (map[string]string, error) {
+	paths := map[string]string{}
+	for name, mode := range namespaces.Joined() {
+		pid, err := namespacePid(name, mode)
+		if err != nil {
+			return nil, err
+		}
+		paths[name] = fmt.Sprintf("/proc/%s/ns/%s", pid, name)
+	}
+	return paths, nil
+}
+
+func namespacePid(name, mode string) (string, error) {
+	if strings.HasPrefix(mode, container.NamespacePodPrefix) {
+		podName := strings.TrimPrefix(mode, container.NamespacePodPrefix)
+		pod, err := container.GetPodInfo(podName)
+		if err != nil {
+			return "", err
+		}
+		if pod.Status != container.RUNNING {
+			return "", fmt.Errorf("pod %s for %s namespace is not running", podName, name)
+		}
+		return pod.InfraPid, nil
+	}
+	containerName := strings.TrimPrefix(mode, container.NamespaceContainerPrefix)
+	info, err := getContainerInfoByName(containerName)
 	if err != nil {
-		logrus.Infof("sendInitCommand: write err %v.", err)
+		return "", fmt.Errorf("container %s for %s namespace not found", containerName, name)
+	}
+	if info.Status != container.RUNNING {
+		return "", fmt.Errorf("container %s for %s namespace is not running", containerName, name)
+	}
+	return info.Pid, nil
+}
+
+func sendInitConfig(config *container.InitConfig, writePipe *os.File) {
+	logrus.Infof("command: %v", config.Args)
+	bytes, err := json.Marshal(config)
+	if err != nil {
+		logrus.Errorf("sendInitConfig: marshal err %v.", err)
+		writePipe.Close()
+		return
+	}
+	n, err := writePipe.Write(bytes)
+	logrus.Infof("sendInitConfig: write bytes %d", n)
+	if err != nil {
+		logrus.Infof("sendInitConfig: write err %v.", err)
 	}
 
 	writePipe.Close()
 }
 
+// --pids-limit 为 -1 时不限制，否则必须是正整数
+func parsePidsLimit(pidsLimit string) (string, error) {
+	if pidsLimit == "-1" {
+		return "max", nil
+	}
+	if pidsLimit != "" {
+		if n, err := strconv.ParseInt(pidsLimit, 10, 64); err != nil || n <= 0 {
+			return "", fmt.Errorf("invalid pids-limit %q, must be a positive number or -1", pidsLimit)
+		}
+	}
+	return pidsLimit, nil
+}
+
 func generateRandomID(n int) string {
 	rand.Seed(time.Now().UnixNano())
 	candidate := "1234567890"
@@ -159,49 +368,42 @@ func generateRandomID(n int) string {
 }
 
 // 记录容器的信息
-func recordContainerInfo(containerPid int, commandArray []string, containerName, containerID, volume string) (string, error) {
+func recordContainerInfo(containerPid int, commandArray []string, containerInfo *container.ContainerInfo) error {
 	// current time of creating container
-	createTime := time.Now().Format("2006-01-02 15:04:05")
-	command := strings.Join(commandArray, "")
-
-	containerInfo := &container.ContainerInfo{
-		Pid:         strconv.Itoa(containerPid),
-		Id:          containerID,
-		Name:        containerName,
-		Command:     command,
-		CreatedTime: createTime,
-		Status:      container.RUNNING,
-		Volume:      volume,
-	}
+	containerInfo.CreatedTime = time.Now().Format("2006-01-02 15:04:05")
+	containerInfo.Command = strings.Join(commandArray, "")
+	containerInfo.Pid = strconv.Itoa(containerPid)
+	containerInfo.Status = container.RUNNING
+
 	// 将容器信息的对象json序列化成字符串
 	bytes, err := json.Marshal(containerInfo)
 	if err != nil {
 		logrus.Errorf("record container info error %v.", err)
-		return "", nil
+		return err
 	}
 	jsonStr := string(bytes)
 
 	// 拼接存储容器信息的存储路径
-	storagePath := fmt.Sprintf(container.DefaultInfoLocation, containerName)
-	if err := os.MkdirAll(storagePath, 0622); err != nil {
+	storagePath := fmt.Sprintf(container.DefaultInfoLocation, containerInfo.Name)
+	if err := os.MkdirAll(storagePath, 0700); err != nil {
 		logrus.Errorf("mkdir failed %s. error %v.", storagePath, err)
-		return "", nil
+		return err
 	}
 	fileName := storagePath + container.ConfigName
 	// 创建配置文件
 	file, err := os.Create(fileName)
-	defer file.Close()
 	if err != nil {
-		logrus.Errorf("create file %s failed. error %v.", file, err)
-		return "", nil
+		logrus.Errorf("create file %s failed. error %v.", fileName, err)
+		return err
 	}
+	defer file.Close()
 
 	// 将数据写入文件
 	if _, err := file.WriteString(jsonStr); err != nil {
 		logrus.Errorf("write file failed. error %v.", err)
-		return "", nil
+		return err
 	}
-	return containerName, nil
+	return nil
 }
 
 func deleteContainerInfo(containerName string) {
@@ -210,3 +412,24 @@ func deleteContainerInfo(containerName string) {
 		logrus.Errorf("Remove dir %s error %v", dirURL, err)
 	}
 }
+
+// 容器的主机名，共享 uts namespace 时与宿主机或被共享的容器相同，否则默认为容器Id
+func sharedHostname(utsMode, containerID string) (string, error) {
+	switch {
+	case utsMode == container.NamespaceHost:
+		return os.Hostname()
+	case strings.HasPrefix(utsMode, container.NamespaceContainerPrefix):
+		info, err := getContainerInfoByName(strings.TrimPrefix(utsMode, container.NamespaceContainerPrefix))
+		if err != nil {
+			return "", err
+		}
+		return info.Hostname, nil
+	case strings.HasPrefix(utsMode, container.NamespacePodPrefix):
+		pod, err := container.GetPodInfo(strings.TrimPrefix(utsMode, container.NamespacePodPrefix))
+		if err != nil {
+			return "", err
+		}
+		return pod.Hostname, nil
+	}
+	return containerID, nil
+}
@@ -0,0 +1,23 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/kkBill/mydocker/container"
+)
+
+// exec 进程通过 containerCgroupPath 加入容器的 cgroup，必须与 run 创建的 cgroup 一致
+func TestContainerCgroupPath(t *testing.T) {
+	tests := []struct {
+		info *container.ContainerInfo
+		want string
+	}{
+		{&container.ContainerInfo{Name: "web"}, "mydocker-cgroup-web"},
+		{&container.ContainerInfo{Name: "web", Pod: "p1"}, container.PodCgroupPath("p1") + "/web"},
+	}
+	for _, tt := range tests {
+		if got := containerCgroupPath(tt.info); got != tt.want {
+			t.Errorf("containerCgroupPath(%q, pod %q) = %q, want %q", tt.info.Name, tt.info.Pod, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,378 @@
+package seccomp
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// 经典 BPF 指令的编码，见 linux/filter.h
+const (
+	bpfLD  = 0x00
+	bpfW   = 0x00
+	bpfABS = 0x20
+	bpfALU = 0x04
+	bpfAND = 0x50
+	bpfJMP = 0x05
+	bpfJEQ = 0x10
+	bpfJGT = 0x20
+	bpfJGE = 0x30
+	bpfK   = 0x00
+	bpfRET = 0x06
+)
+
+// struct seccomp_data 中各字段的偏移
+const (
+	offsetNr   = 0
+	offsetArch = 4
+	offsetArgs = 16
+)
+
+const auditArchX86_64 = 0xc000003e
+
+// seccomp 过滤器的返回值，见 linux/seccomp.h
+const (
+	retKillProcess = 0x80000000
+	retKillThread  = 0x00000000
+	retTrap        = 0x00030000
+	retErrno       = 0x00050000
+	retTrace       = 0x7ff00000
+	retLog         = 0x7ffc0000
+	retAllow       = 0x7fff0000
+)
+
+// 把配置文件中的动作转换为过滤器的返回值，ERRNO 默认返回 EPERM
+func actionValue(action Action, errnoRet *uint) (uint32, error) {
+	data := uint32(0)
+	if errnoRet != nil {
+		data = uint32(*errnoRet) & 0xffff
+	}
+	switch action {
+	case ActKill, ActKillThread:
+		return retKillThread, nil
+	case ActKillProcess:
+		return retKillProcess, nil
+	case ActTrap:
+		return retTrap, nil
+	case ActErrno:
+		if errnoRet == nil {
+			data = uint32(syscall.EPERM)
+		}
+		return retErrno | data, nil
+	case ActTrace:
+		return retTrace | data, nil
+	case ActLog:
+		return retLog, nil
+	case ActAllow:
+		return retAllow, nil
+	}
+	return 0, fmt.Errorf("unknown seccomp action %q", action)
+}
+
+// 一条待回填跳转距离的指令，jtLabel、jfLabel 大于 0 时表示跳转到对应的标签
+type instruction struct {
+	syscall.SockFilter
+	jtLabel int
+	jfLabel int
+}
+
+// 简单的汇编器，经典 BPF 只能向前跳转，且跳转距离不能超过 255 条指令
+type assembler struct {
+	insns  []instruction
+	labels map[int]int
+	next   int
+}
+
+func newAssembler() *assembler {
+	return &assembler{labels: map[int]int{}}
+}
+
+func (a *assembler) newLabel() int {
+	a.next++
+	return a.next
+}
+
+func (a *assembler) mark(label int) {
+	a.labels[label] = len(a.insns)
+}
+
+func (a *assembler) stmt(code uint16, k uint32) {
+	a.insns = append(a.insns, instruction{SockFilter: syscall.SockFilter{Code: code, K: k}})
+}
+
+// 条件跳转，标签为 0 表示继续执行下一条指令
+func (a *assembler) jump(code uint16, k uint32, jtLabel, jfLabel int) {
+	a.insns = append(a.insns, instruction{SockFilter: syscall.SockFilter{Code: bpfJMP | code | bpfK, K: k}, jtLabel: jtLabel, jfLabel: jfLabel})
+}
+
+// 跳转距离固定的条件跳转
+func (a *assembler) skip(code uint16, k uint32, jt, jf uint8) {
+	a.insns = append(a.insns, instruction{SockFilter: syscall.SockFilter{Code: bpfJMP | code | bpfK, Jt: jt, Jf: jf, K: k}})
+}
+
+func (a *assembler) load(offset uint32) {
+	a.stmt(bpfLD|bpfW|bpfABS, offset)
+}
+
+func (a *assembler) ret(value uint32) {
+	a.stmt(bpfRET|bpfK, value)
+}
+
+func (a *assembler) assemble() ([]syscall.SockFilter, error) {
+	filter := make([]syscall.SockFilter, len(a.insns))
+	for i, insn := range a.insns {
+		filter[i] = insn.SockFilter
+		for _, j := range []struct {
+			label int
+			dst   *uint8
+		}{{insn.jtLabel, &filter[i].Jt}, {insn.jfLabel, &filter[i].Jf}} {
+			if j.label == 0 {
+				continue
+			}
+			target, ok := a.labels[j.label]
+			if !ok {
+				return nil, fmt.Errorf("undefined label %d", j.label)
+			}
+			offset := target - i - 1
+			if offset < 0 || offset > 255 {
+				return nil, fmt.Errorf("jump offset %d out of range", offset)
+			}
+			*j.dst = uint8(offset)
+		}
+	}
+	return filter, nil
+}
+
+// 当前架构是否支持 seccomp 过滤器，目前只支持 x86_64
+func Supported() bool {
+	return nativeArch != 0
+}
+
+// 编译出来的规则：系统调用号、参数条件和返回值
+type rule struct {
+	nr     int
+	args   []*Arg
+	action uint32
+}
+
+// 把配置文件编译为 BPF 过滤器，caps 是容器进程保留的 capability，用于判断规则的 includes/excludes：
+// 1.不是本机架构（包括 x32 ABI）的系统调用直接执行默认动作
+// 2.按配置文件中的顺序逐条比较系统调用号和参数，第一条匹配的规则生效
+// 3.都不匹配时执行默认动作
+// 本机架构上不存在的系统调用名会被忽略，与 docker 的行为一致
+func Compile(profile *Profile, caps []string) ([]syscall.SockFilter, error) {
+	if !Supported() {
+		return nil, fmt.Errorf("seccomp is not supported on this architecture")
+	}
+	defaultAction, err := actionValue(profile.DefaultAction, profile.DefaultErrnoRet)
+	if err != nil {
+		return nil, err
+	}
+	kernel := kernelVersion()
+	var rules []rule
+	for _, s := range profile.Syscalls {
+		if !s.Includes.match(caps, kernel, true) || s.Excludes.match(caps, kernel, false) {
+			continue
+		}
+		action, err := actionValue(s.Action, s.ErrnoRet)
+		if err != nil {
+			return nil, err
+		}
+		names := s.Names
+		if s.Name != "" {
+			names = append(names, s.Name)
+		}
+		for _, name := range names {
+			if nr, ok := syscallTable[name]; ok {
+				rules = append(rules, rule{nr: nr, args: s.Args, action: action})
+			}
+		}
+	}
+
+	a := newAssembler()
+	a.load(offsetArch)
+	a.skip(bpfJEQ, nativeArch, 1, 0)
+	a.ret(defaultAction)
+	a.load(offsetNr)
+	a.skip(bpfJGE, x32SyscallBit, 0, 1)
+	a.ret(defaultAction)
+	for _, r := range rules {
+		if len(r.args) == 0 {
+			a.skip(bpfJEQ, uint32(r.nr), 0, 1)
+			a.ret(r.action)
+			continue
+		}
+		// 比较参数会覆盖累加器中的系统调用号，条件不满足时需要重新加载
+		next, reload := a.newLabel(), a.newLabel()
+		a.jump(bpfJEQ, uint32(r.nr), 0, next)
+		for _, arg := range r.args {
+			compileArg(a, arg, reload)
+		}
+		a.ret(r.action)
+		a.mark(reload)
+		a.load(offsetNr)
+		a.mark(next)
+	}
+	a.ret(defaultAction)
+	return a.assemble()
+}
+
+// 编译一个参数条件，条件满足时继续执行，不满足时跳转到 fail
+// 参数是 64 位的，需要分别比较高 32 位和低 32 位
+func compileArg(a *assembler, arg *Arg, fail int) {
+	lo := uint32(offsetArgs + 8*arg.Index)
+	hi := lo + 4
+	value := arg.Value
+	if arg.Op == OpMaskedEqual {
+		value = arg.ValueTwo
+	}
+	vhi, vlo := uint32(value>>32), uint32(value)
+	pass := a.newLabel()
+	switch arg.Op {
+	case OpEqualTo:
+		a.load(hi)
+		a.jump(bpfJEQ, vhi, 0, fail)
+		a.load(lo)
+		a.jump(bpfJEQ, vlo, 0, fail)
+	case OpNotEqual:
+		a.load(hi)
+		a.jump(bpfJEQ, vhi, 0, pass)
+		a.load(lo)
+		a.jump(bpfJEQ, vlo, fail, 0)
+	case OpMaskedEqual:
+		mask := arg.Value
+		a.load(hi)
+		a.stmt(bpfALU|bpfAND|bpfK, uint32(mask>>32))
+		a.jump(bpfJEQ, vhi, 0, fail)
+		a.load(lo)
+		a.stmt(bpfALU|bpfAND|bpfK, uint32(mask))
+		a.jump(bpfJEQ, vlo, 0, fail)
+	case OpGreaterThan, OpGreaterEqual:
+		// 高 32 位大于时满足，小于时不满足，相等时再比较低 32 位
+		a.load(hi)
+		a.jump(bpfJGT, vhi, pass, 0)
+		a.jump(bpfJEQ, vhi, 0, fail)
+		a.load(lo)
+		if arg.Op == OpGreaterThan {
+			a.jump(bpfJGT, vlo, 0, fail)
+		} else {
+			a.jump(bpfJGE, vlo, 0, fail)
+		}
+	case OpLessThan, OpLessEqual:
+		a.load(hi)
+		a.jump(bpfJGE, vhi, 0, pass)
+		a.jump(bpfJEQ, vhi, 0, fail)
+		a.load(lo)
+		if arg.Op == OpLessThan {
+			a.jump(bpfJGE, vlo, fail, 0)
+		} else {
+			a.jump(bpfJGT, vlo, fail, 0)
+		}
+	}
+	a.mark(pass)
+}
+
+// 判断规则的 includes/excludes 条件，filter 为空时返回 include
+// includes 需要所有条件都满足，excludes 只要有一个条件满足就排除该规则
+func (f *Filter) match(caps []string, kernel []int, include bool) bool {
+	if f == nil {
+		return include
+	}
+	var results []bool
+	if len(f.Caps) > 0 {
+		hasAll, hasAny := true, false
+		for _, c := range f.Caps {
+			if contains(caps, c) {
+				hasAny = true
+			} else {
+				hasAll = false
+			}
+		}
+		results = append(results, include && hasAll || !include && hasAny)
+	}
+	if len(f.Arches) > 0 {
+		matched := false
+		for _, arch := range f.Arches {
+			if contains(nativeArchNames, arch) {
+				matched = true
+			}
+		}
+		results = append(results, matched)
+	}
+	if f.MinKernel != "" {
+		results = append(results, compareVersion(kernel, parseVersion(f.MinKernel)) >= 0)
+	}
+	if len(results) == 0 {
+		return include
+	}
+	for _, r := range results {
+		if include && !r {
+			return false
+		}
+		if !include && r {
+			return true
+		}
+	}
+	return include
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if strings.EqualFold(item, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// 当前内核的版本号，例如 5.10.0-8-amd64 解析为 [5 10 0]
+func kernelVersion() []int {
+	var uname syscall.Utsname
+	if err := syscall.Uname(&uname); err != nil {
+		return nil
+	}
+	var release []byte
+	for _, c := range uname.Release {
+		if c == 0 {
+			break
+		}
+		release = append(release, byte(c))
+	}
+	return parseVersion(string(release))
+}
+
+func parseVersion(version string) []int {
+	var parts []int
+	for _, field := range strings.SplitN(version, ".", 3) {
+		end := 0
+		for end < len(field) && field[end] >= '0' && field[end] <= '9' {
+			end++
+		}
+		n, err := strconv.Atoi(field[:end])
+		if err != nil {
+			break
+		}
+		parts = append(parts, n)
+	}
+	return parts
+}
+
+func compareVersion(a, b []int) int {
+	for i := 0; i < len(a) || i < len(b); i++ {
+		var x, y int
+		if i < len(a) {
+			x = a[i]
+		}
+		if i < len(b) {
+			y = b[i]
+		}
+		if x != y {
+			if x < y {
+				return -1
+			}
+			return 1
+		}
+	}
+	return 0
+}
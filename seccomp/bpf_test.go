@@ -0,0 +1,153 @@
+package seccomp
+
+import (
+	"encoding/binary"
+	"syscall"
+	"testing"
+)
+
+// 用一个简单的 BPF 解释器执行编译出来的过滤器，返回过滤器的返回值
+func runFilter(t *testing.T, filter []syscall.SockFilter, arch uint32, nr int, args ...uint64) uint32 {
+	data := make([]byte, 64)
+	binary.LittleEndian.PutUint32(data[offsetNr:], uint32(nr))
+	binary.LittleEndian.PutUint32(data[offsetArch:], arch)
+	for i, arg := range args {
+		binary.LittleEndian.PutUint64(data[offsetArgs+8*i:], arg)
+	}
+	var acc uint32
+	for pc := 0; pc < len(filter); pc++ {
+		insn := filter[pc]
+		switch {
+		case insn.Code == bpfLD|bpfW|bpfABS:
+			acc = binary.LittleEndian.Uint32(data[insn.K:])
+		case insn.Code == bpfALU|bpfAND|bpfK:
+			acc &= insn.K
+		case insn.Code == bpfRET|bpfK:
+			return insn.K
+		case insn.Code&0x07 == bpfJMP:
+			var cond bool
+			switch insn.Code & 0xf0 {
+			case bpfJEQ:
+				cond = acc == insn.K
+			case bpfJGT:
+				cond = acc > insn.K
+			case bpfJGE:
+				cond = acc >= insn.K
+			default:
+				t.Fatalf("unknown jump %#x", insn.Code)
+			}
+			if cond {
+				pc += int(insn.Jt)
+			} else {
+				pc += int(insn.Jf)
+			}
+		default:
+			t.Fatalf("unknown instruction %#x", insn.Code)
+		}
+	}
+	t.Fatalf("filter has no return")
+	return 0
+}
+
+func TestCompileArgs(t *testing.T) {
+	if nativeArch == 0 {
+		t.Skip("seccomp is not supported on this architecture")
+	}
+	profile, err := ParseProfile([]byte(`{
+		"defaultAction": "SCMP_ACT_ERRNO",
+		"syscalls": [
+			{"names": ["read", "write"], "action": "SCMP_ACT_ALLOW"},
+			{"names": ["no_such_syscall"], "action": "SCMP_ACT_ALLOW"},
+			{"names": ["personality"], "action": "SCMP_ACT_ALLOW", "args": [{"index": 0, "value": 8, "op": "SCMP_CMP_EQ"}]},
+			{"names": ["dup"], "action": "SCMP_ACT_ALLOW", "args": [{"index": 0, "value": 4294967296, "op": "SCMP_CMP_GE"}]},
+			{"names": ["dup2"], "action": "SCMP_ACT_ALLOW", "args": [{"index": 1, "value": 3, "op": "SCMP_CMP_LT"}]},
+			{"names": ["clone"], "action": "SCMP_ACT_ALLOW", "args": [{"index": 0, "value": 255, "valueTwo": 17, "op": "SCMP_CMP_MASKED_EQ"}]},
+			{"names": ["kill"], "action": "SCMP_ACT_ERRNO", "errnoRet": 38, "args": [{"index": 1, "value": 9, "op": "SCMP_CMP_NE"}]}
+		]
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	filter, err := Compile(profile, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	eperm := retErrno | uint32(syscall.EPERM)
+	tests := []struct {
+		name string
+		arch uint32
+		nr   int
+		args []uint64
+		want uint32
+	}{
+		{"read", nativeArch, syscallTable["read"], nil, retAllow},
+		{"write", nativeArch, syscallTable["write"], nil, retAllow},
+		{"open", nativeArch, syscallTable["open"], nil, eperm},
+		{"foreign arch", 0x40000003, syscallTable["read"], nil, eperm},
+		{"personality eq", nativeArch, syscallTable["personality"], []uint64{8}, retAllow},
+		{"personality high bits", nativeArch, syscallTable["personality"], []uint64{1<<32 | 8}, eperm},
+		{"dup ge", nativeArch, syscallTable["dup"], []uint64{1 << 33}, retAllow},
+		{"dup lt", nativeArch, syscallTable["dup"], []uint64{1<<32 - 1}, eperm},
+		{"dup2 lt", nativeArch, syscallTable["dup2"], []uint64{0, 2}, retAllow},
+		{"dup2 eq", nativeArch, syscallTable["dup2"], []uint64{0, 3}, eperm},
+		{"clone masked", nativeArch, syscallTable["clone"], []uint64{0x1100 | 17}, retAllow},
+		{"clone masked mismatch", nativeArch, syscallTable["clone"], []uint64{18}, eperm},
+		{"kill ne", nativeArch, syscallTable["kill"], []uint64{1, 15}, retErrno | 38},
+		{"kill eq", nativeArch, syscallTable["kill"], []uint64{1, 9}, eperm},
+	}
+	for _, test := range tests {
+		if got := runFilter(t, filter, test.arch, test.nr, test.args...); got != test.want {
+			t.Errorf("%s: got %#x, want %#x", test.name, got, test.want)
+		}
+	}
+}
+
+func TestCompileCaps(t *testing.T) {
+	if nativeArch == 0 {
+		t.Skip("seccomp is not supported on this architecture")
+	}
+	profile := DefaultProfile()
+	mount := syscallTable["mount"]
+	eperm := retErrno | uint32(syscall.EPERM)
+
+	filter, err := Compile(profile, []string{"CAP_CHOWN"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := runFilter(t, filter, nativeArch, mount); got != eperm {
+		t.Errorf("mount without CAP_SYS_ADMIN: got %#x", got)
+	}
+	if got := runFilter(t, filter, nativeArch, syscallTable["clone"], syscall.CLONE_NEWNET); got != eperm {
+		t.Errorf("clone with CLONE_NEWNET without CAP_SYS_ADMIN: got %#x", got)
+	}
+	if got := runFilter(t, filter, nativeArch, syscallTable["clone"], syscall.CLONE_VM|syscall.CLONE_THREAD); got != retAllow {
+		t.Errorf("clone thread without CAP_SYS_ADMIN: got %#x", got)
+	}
+	if got := runFilter(t, filter, nativeArch, syscallTable["clone3"]); got != retErrno|uint32(syscall.ENOSYS) {
+		t.Errorf("clone3 without CAP_SYS_ADMIN: got %#x", got)
+	}
+
+	filter, err = Compile(profile, []string{"CAP_SYS_ADMIN"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := runFilter(t, filter, nativeArch, mount); got != retAllow {
+		t.Errorf("mount with CAP_SYS_ADMIN: got %#x", got)
+	}
+	if got := runFilter(t, filter, nativeArch, syscallTable["clone"], syscall.CLONE_NEWNET); got != retAllow {
+		t.Errorf("clone with CLONE_NEWNET with CAP_SYS_ADMIN: got %#x", got)
+	}
+}
+
+func TestParseProfileErrors(t *testing.T) {
+	for _, profile := range []string{
+		`{"defaultAction": "SCMP_ACT_NOPE"}`,
+		`{"defaultAction": "SCMP_ACT_ALLOW", "syscalls": [{"names": ["read"], "action": "SCMP_ACT_BAD"}]}`,
+		`{"defaultAction": "SCMP_ACT_ALLOW", "syscalls": [{"names": ["read"], "action": "SCMP_ACT_ALLOW", "args": [{"index": 6, "op": "SCMP_CMP_EQ"}]}]}`,
+		`{"defaultAction": "SCMP_ACT_ALLOW", "syscalls": [{"names": ["read"], "action": "SCMP_ACT_ALLOW", "args": [{"index": 0, "op": "SCMP_CMP_XX"}]}]}`,
+	} {
+		if _, err := ParseProfile([]byte(profile)); err == nil {
+			t.Errorf("ParseProfile(%s) expected error", profile)
+		}
+	}
+}
@@ -0,0 +1,126 @@
+package seccomp
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+)
+
+// --security-opt seccomp= 的特殊取值
+const (
+	ProfileDefault    = "default"    //使用内置的默认配置
+	ProfileUnconfined = "unconfined" //不过滤系统调用
+)
+
+// 与 docker 兼容的 seccomp 配置文件
+type Profile struct {
+	DefaultAction   Action     `json:"defaultAction"`
+	DefaultErrnoRet *uint      `json:"defaultErrnoRet,omitempty"`
+	Architectures   []string   `json:"architectures,omitempty"`
+	ArchMap         []ArchMap  `json:"archMap,omitempty"`
+	Syscalls        []*Syscall `json:"syscalls"`
+}
+
+type ArchMap struct {
+	Arch             string   `json:"architecture"`
+	SubArchitectures []string `json:"subArchitectures"`
+}
+
+// 一组系统调用的规则，Args 中的条件同时满足时才执行 Action
+type Syscall struct {
+	Names    []string `json:"names,omitempty"`
+	Name     string   `json:"name,omitempty"` //老版本的配置文件每条规则只有一个系统调用
+	Action   Action   `json:"action"`
+	ErrnoRet *uint    `json:"errnoRet,omitempty"`
+	Args     []*Arg   `json:"args"`
+	Comment  string   `json:"comment,omitempty"`
+	Includes *Filter  `json:"includes,omitempty"`
+	Excludes *Filter  `json:"excludes,omitempty"`
+}
+
+// 对系统调用第 Index 个参数的判断，MASKED_EQ 时 Value 是掩码，ValueTwo 是比较的值
+type Arg struct {
+	Index    uint     `json:"index"`
+	Value    uint64   `json:"value"`
+	ValueTwo uint64   `json:"valueTwo"`
+	Op       Operator `json:"op"`
+}
+
+// 规则生效的条件：容器拥有全部 Caps、本机架构在 Arches 中、内核版本不低于 MinKernel
+type Filter struct {
+	Caps      []string `json:"caps,omitempty"`
+	Arches    []string `json:"arches,omitempty"`
+	MinKernel string   `json:"minKernel,omitempty"`
+}
+
+type Action string
+
+const (
+	ActKill        Action = "SCMP_ACT_KILL"
+	ActKillThread  Action = "SCMP_ACT_KILL_THREAD"
+	ActKillProcess Action = "SCMP_ACT_KILL_PROCESS"
+	ActTrap        Action = "SCMP_ACT_TRAP"
+	ActErrno       Action = "SCMP_ACT_ERRNO"
+	ActTrace       Action = "SCMP_ACT_TRACE"
+	ActAllow       Action = "SCMP_ACT_ALLOW"
+	ActLog         Action = "SCMP_ACT_LOG"
+)
+
+type Operator string
+
+const (
+	OpNotEqual     Operator = "SCMP_CMP_NE"
+	OpLessThan     Operator = "SCMP_CMP_LT"
+	OpLessEqual    Operator = "SCMP_CMP_LE"
+	OpEqualTo      Operator = "SCMP_CMP_EQ"
+	OpGreaterEqual Operator = "SCMP_CMP_GE"
+	OpGreaterThan  Operator = "SCMP_CMP_GT"
+	OpMaskedEqual  Operator = "SCMP_CMP_MASKED_EQ"
+)
+
+// 根据 --security-opt seccomp= 的取值加载配置：
+// 空或 default 使用内置的默认配置，unconfined 返回 nil，其他取值当作配置文件的路径
+func LoadProfile(name string) (*Profile, error) {
+	switch name {
+	case "", ProfileDefault:
+		return DefaultProfile(), nil
+	case ProfileUnconfined:
+		return nil, nil
+	}
+	bytes, err := ioutil.ReadFile(name)
+	if err != nil {
+		return nil, fmt.Errorf("read seccomp profile %s error %v", name, err)
+	}
+	profile, err := ParseProfile(bytes)
+	if err != nil {
+		return nil, fmt.Errorf("seccomp profile %s: %v", name, err)
+	}
+	return profile, nil
+}
+
+// 解析 JSON 格式的配置文件，并检查其中的动作和比较操作是否合法
+func ParseProfile(bytes []byte) (*Profile, error) {
+	var profile Profile
+	if err := json.Unmarshal(bytes, &profile); err != nil {
+		return nil, err
+	}
+	if _, err := actionValue(profile.DefaultAction, profile.DefaultErrnoRet); err != nil {
+		return nil, err
+	}
+	for _, rule := range profile.Syscalls {
+		if _, err := actionValue(rule.Action, rule.ErrnoRet); err != nil {
+			return nil, err
+		}
+		for _, arg := range rule.Args {
+			if arg.Index > 5 {
+				return nil, fmt.Errorf("invalid argument index %d", arg.Index)
+			}
+			switch arg.Op {
+			case OpNotEqual, OpLessThan, OpLessEqual, OpEqualTo, OpGreaterEqual, OpGreaterThan, OpMaskedEqual:
+			default:
+				return nil, fmt.Errorf("unknown seccomp operator %q", arg.Op)
+			}
+		}
+	}
+	return &profile, nil
+}
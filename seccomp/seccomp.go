@@ -0,0 +1,46 @@
+package seccomp
+
+import (
+	"fmt"
+	"syscall"
+	"unsafe"
+)
+
+const (
+	prSetNoNewPrivs        = 38
+	prSetSeccomp           = 22
+	seccompModeFilter      = 2
+	seccompSetModeFilter   = 1
+	seccompFilterFlagTsync = 1
+)
+
+// 在当前进程中安装 seccomp 过滤器，profile 为 nil 表示 unconfined：
+// 1.设置 no_new_privs，没有 CAP_SYS_ADMIN 的进程必须先设置它才能安装过滤器，同时禁止 exec 时通过 setuid 程序提权
+// 2.通过 seccomp 系统调用安装过滤器并同步到所有线程，内核不支持时退回到 prctl
+// 过滤器安装之后进程能执行的系统调用就受到了限制，应该在 exec 用户命令之前最后一步调用
+func InitSeccomp(profile *Profile, caps []string) error {
+	if profile == nil {
+		return nil
+	}
+	filter, err := Compile(profile, caps)
+	if err != nil {
+		return err
+	}
+	if _, _, errno := syscall.RawSyscall6(syscall.SYS_PRCTL, prSetNoNewPrivs, 1, 0, 0, 0, 0); errno != 0 {
+		return fmt.Errorf("set no_new_privs error %v", errno)
+	}
+	prog := syscall.SockFprog{Len: uint16(len(filter)), Filter: &filter[0]}
+	if nr, ok := syscallTable["seccomp"]; ok {
+		_, _, errno := syscall.RawSyscall(uintptr(nr), seccompSetModeFilter, seccompFilterFlagTsync, uintptr(unsafe.Pointer(&prog)))
+		if errno == 0 {
+			return nil
+		}
+		if errno != syscall.ENOSYS {
+			return fmt.Errorf("install seccomp filter error %v", errno)
+		}
+	}
+	if _, _, errno := syscall.RawSyscall6(syscall.SYS_PRCTL, prSetSeccomp, seccompModeFilter, uintptr(unsafe.Pointer(&prog)), 0, 0, 0); errno != 0 {
+		return fmt.Errorf("install seccomp filter error %v", errno)
+	}
+	return nil
+}
@@ -0,0 +1,13 @@
+//go:build !amd64
+// +build !amd64
+
+package seccomp
+
+// 其他架构暂不支持，编译过滤器时返回错误
+const nativeArch = 0
+
+const x32SyscallBit = 0
+
+var nativeArchNames []string
+
+var syscallTable = map[string]int{}
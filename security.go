@@ -0,0 +1,70 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/kkBill/mydocker/landlock"
+	"github.com/kkBill/mydocker/seccomp"
+)
+
+// --security-opt 解析后的结果
+type securityOpts struct {
+	Seccomp  string //seccomp 配置，default、unconfined 或配置文件的路径
+	Landlock string //landlock 配置文件的路径，为空表示不使用 landlock
+}
+
+// 解析 --security-opt，目前支持：
+// seccomp=default|unconfined|配置文件路径，没有指定时普通容器使用默认配置，特权容器不过滤系统调用
+// 当前架构不支持 seccomp 时，没有指定 seccomp 的容器不过滤系统调用，显式指定的配置直接报错
+// landlock=配置文件路径，同时检查内核是否支持 landlock
+func parseSecurityOpts(opts []string, privileged bool) (*securityOpts, error) {
+	result := &securityOpts{Seccomp: seccomp.ProfileDefault}
+	if privileged {
+		result.Seccomp = seccomp.ProfileUnconfined
+	}
+	seccompSet := false
+	for _, opt := range opts {
+		kv := strings.SplitN(opt, "=", 2)
+		if len(kv) != 2 {
+			// 兼容 docker 老版本 seccomp:xxx 的写法
+			kv = strings.SplitN(opt, ":", 2)
+		}
+		if len(kv) != 2 || kv[1] == "" {
+			return nil, fmt.Errorf("invalid security opt %q, must be key=value", opt)
+		}
+		switch kv[0] {
+		case "seccomp":
+			result.Seccomp = kv[1]
+			seccompSet = true
+		case "landlock":
+			result.Landlock = kv[1]
+		default:
+			return nil, fmt.Errorf("unknown security opt %q", kv[0])
+		}
+	}
+	if !seccomp.Supported() && !seccompSet && result.Seccomp == seccomp.ProfileDefault {
+		logrus.Warnf("seccomp is not supported on this architecture, running the container unconfined")
+		result.Seccomp = seccomp.ProfileUnconfined
+	}
+	profile, err := seccomp.LoadProfile(result.Seccomp)
+	if err != nil {
+		return nil, err
+	}
+	// 提前编译一次，让不支持的架构或者错误的配置在创建容器之前就报错，而不是在容器 init 进程中失败
+	if profile != nil {
+		if _, err := seccomp.Compile(profile, nil); err != nil {
+			return nil, fmt.Errorf("seccomp profile %s: %v", result.Seccomp, err)
+		}
+	}
+	if result.Landlock != "" {
+		if _, err := landlock.ABIVersion(); err != nil {
+			return nil, err
+		}
+		if _, err := landlock.LoadPolicy(result.Landlock); err != nil {
+			return nil, err
+		}
+	}
+	return result, nil
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/kkBill/mydocker/seccomp"
+)
+
+func TestParseSecurityOptsSeccomp(t *testing.T) {
+	tests := []struct {
+		opts       []string
+		privileged bool
+		want       string
+		wantErr    bool
+	}{
+		{nil, false, seccomp.ProfileDefault, false},
+		{nil, true, seccomp.ProfileUnconfined, false},
+		{[]string{"seccomp=unconfined"}, false, seccomp.ProfileUnconfined, false},
+		{[]string{"seccomp=default"}, false, seccomp.ProfileDefault, false},
+		{[]string{"seccomp=/nonexistent.json"}, false, "", true},
+	}
+	for _, tt := range tests {
+		want, wantErr := tt.want, tt.wantErr
+		if !seccomp.Supported() && want == seccomp.ProfileDefault {
+			// 不支持 seccomp 的架构上，默认配置退回到 unconfined，显式指定的配置直接报错
+			if len(tt.opts) == 0 {
+				want = seccomp.ProfileUnconfined
+			} else {
+				wantErr = true
+			}
+		}
+		got, err := parseSecurityOpts(tt.opts, tt.privileged)
+		if (err != nil) != wantErr {
+			t.Errorf("parseSecurityOpts(%v, %v) error = %v, wantErr %v", tt.opts, tt.privileged, err, wantErr)
+			continue
+		}
+		if err == nil && got.Seccomp != want {
+			t.Errorf("parseSecurityOpts(%v, %v) seccomp = %q, want %q", tt.opts, tt.privileged, got.Seccomp, want)
+		}
+	}
+}
@@ -9,6 +9,7 @@ import (
 	"os"
 	"strconv"
 	"syscall"
+	"time"
 )
 
 func stopContainer(containerName string) {
@@ -29,25 +30,93 @@ func stopContainer(containerName string) {
 		logrus.Errorf("Stop container %s error %v", containerName, err)
 		return
 	}
+	// 容器的 1 号进程没有处理 SIGTERM 时会忽略这个信号，等待一段时间之后仍未退出就发送 SIGKILL
+	if !waitProcessExit(pidInt, timeoutGracePeriod) {
+		logrus.Warnf("Container %s did not exit after SIGTERM, killing it", containerName)
+		if err := syscall.Kill(pidInt, syscall.SIGKILL); err != nil && err != syscall.ESRCH {
+			logrus.Errorf("Kill container %s error %v", containerName, err)
+			return
+		}
+	}
+	// 至此，容器进程已经被 kill 了，下面要修改容器的状态。
+	// 容器进程退出时 run 或 monitor 进程会同时记录退出码，所以要在锁中只修改状态，不能覆盖其他字段
+	err = modifyContainerInfo(containerName, func(containerInfo *container.ContainerInfo) {
+		containerInfo.Status = container.STOP
+		containerInfo.Pid = "--"
+	})
+	if err != nil {
+		logrus.Errorf("Update container %s info error %v", containerName, err)
+	}
+}
+
+// 等待进程退出，超时返回 false
+func waitProcessExit(pid int, timeout time.Duration) bool {
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		if syscall.Kill(pid, 0) != nil {
+			return true
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	return false
+}
+
+// 把修改后的容器信息重新写入配置文件
+func updateContainerInfo(containerInfo *container.ContainerInfo) error {
+	unlock, err := lockContainerInfo(containerInfo.Name)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	return writeContainerInfo(containerInfo)
+}
+
+// 在锁中读取、修改并写回容器信息，避免 stop 和容器退出时的记录同时读写配置文件，后写入的一方覆盖另一方的修改
+func modifyContainerInfo(containerName string, modify func(containerInfo *container.ContainerInfo)) error {
+	unlock, err := lockContainerInfo(containerName)
+	if err != nil {
+		return err
+	}
+	defer unlock()
 	containerInfo, err := getContainerInfoByName(containerName)
 	if err != nil {
-		logrus.Errorf("Get container %s info error %v", containerName, err)
-		return
+		return err
 	}
-	// 至此，容器进程已经被 kill 了，下面要修改容器的状态。
-	// 修改好后再重新写入配置文件
-	containerInfo.Status = container.STOP
-	containerInfo.Pid = "--"
+	modify(containerInfo)
+	return writeContainerInfo(containerInfo)
+}
+
+// 对容器信息目录加 flock 排它锁，返回解锁函数
+func lockContainerInfo(containerName string) (func(), error) {
+	dirURL := fmt.Sprintf(container.DefaultInfoLocation, containerName)
+	dir, err := os.Open(dirURL)
+	if err != nil {
+		return nil, fmt.Errorf("open %s error %v", dirURL, err)
+	}
+	if err := syscall.Flock(int(dir.Fd()), syscall.LOCK_EX); err != nil {
+		dir.Close()
+		return nil, fmt.Errorf("lock %s error %v", dirURL, err)
+	}
+	// 关闭文件描述符时锁随之释放
+	return func() { dir.Close() }, nil
+}
+
+// 先写入临时文件再重命名，读取配置文件的一方不会读到写了一半的内容
+func writeContainerInfo(containerInfo *container.ContainerInfo) error {
 	newContentBytes, err := json.Marshal(containerInfo)
 	if err != nil {
-		logrus.Errorf("Json marshal %s error %v", containerName, err)
-		return
+		return fmt.Errorf("json marshal %s error %v", containerInfo.Name, err)
 	}
-	dirURL := fmt.Sprintf(container.DefaultInfoLocation, containerName)
+	dirURL := fmt.Sprintf(container.DefaultInfoLocation, containerInfo.Name)
 	configFilePath := dirURL + container.ConfigName
-	if err := ioutil.WriteFile(configFilePath, newContentBytes, 0622); err != nil {
-		logrus.Errorf("Write file %s error", configFilePath, err)
+	tmpPath := configFilePath + ".tmp"
+	if err := ioutil.WriteFile(tmpPath, newContentBytes, 0622); err != nil {
+		return fmt.Errorf("write file %s error %v", tmpPath, err)
+	}
+	if err := os.Rename(tmpPath, configFilePath); err != nil {
+		return fmt.Errorf("rename %s error %v", tmpPath, err)
 	}
+	return nil
 }
 
 // 根据容器名获取对应的容器信息结构体
@@ -74,8 +143,8 @@ func removeContainer(containerName string) {
 		logrus.Errorf("Get container %s info error %v", containerName, err)
 		return
 	}
-	// 只删除 STOP 状态的容器
-	if containerInfo.Status != container.STOP {
+	// 只删除 STOP 状态或者已经退出的容器
+	if containerInfo.Status != container.STOP && containerInfo.Status != container.Exit {
 		logrus.Errorf("Couldn't remove running container")
 		return
 	}
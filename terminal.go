@@ -0,0 +1,93 @@
+package main
+
+import (
+	"github.com/Sirupsen/logrus"
+	"github.com/kkBill/mydocker/container"
+	"io"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+)
+
+// 宿主机上的终端或标准输入输出与容器之间的连接
+type hostTerminal struct {
+	oldState *syscall.Termios
+	winch    chan os.Signal
+	output   sync.WaitGroup
+}
+
+// 把当前进程的标准输入输出连接到容器：
+// 1.分配了终端并且有 -i 时，把当前终端设置为 raw 模式，按键（包括 Ctrl-C）原样交给容器内的终端处理
+// 2.分配了终端时，把当前终端的窗口大小同步给 pty，并在收到 SIGWINCH 时重新同步
+// 3.转发容器的输出，tty 模式下都写到 stdout 中；-i 时把标准输入转发给容器
+func attachHostTerminal(pio *container.ProcessIO, stdout, stderr io.Writer) *hostTerminal {
+	t := &hostTerminal{}
+	if pio.Console != nil && container.IsTerminal(os.Stdin.Fd()) {
+		if pio.Stdin != nil {
+			oldState, err := container.SetRawTerminal(os.Stdin.Fd())
+			if err != nil {
+				logrus.Errorf("Set raw terminal error %v", err)
+			}
+			t.oldState = oldState
+		}
+
+		resizePty(pio.Console)
+		t.winch = make(chan os.Signal, 1)
+		signal.Notify(t.winch, syscall.SIGWINCH)
+		go func() {
+			for range t.winch {
+				resizePty(pio.Console)
+			}
+		}()
+	}
+	if pio.Stdin != nil {
+		go func() {
+			io.Copy(pio.Stdin, os.Stdin)
+			// 标准输入结束时关闭管道，容器读到 EOF；pty 的 master 端还要读取输出，不能关闭
+			if pio.Console == nil {
+				pio.Stdin.Close()
+			}
+		}()
+	}
+
+	copyOutput := func(dst io.Writer, src io.Reader) {
+		t.output.Add(1)
+		go func() {
+			defer t.output.Done()
+			io.Copy(dst, src)
+		}()
+	}
+	if pio.Console != nil {
+		// 容器内所有进程都关闭 slave 端后，读 master 端会返回 EIO
+		copyOutput(stdout, pio.Console)
+	} else {
+		copyOutput(stdout, pio.Stdout)
+		copyOutput(stderr, pio.Stderr)
+	}
+	return t
+}
+
+// 等待容器的输出全部转发完，然后恢复终端原来的状态
+func (t *hostTerminal) wait() {
+	t.output.Wait()
+	if t.winch != nil {
+		signal.Stop(t.winch)
+		close(t.winch)
+	}
+	if err := container.RestoreTerminal(os.Stdin.Fd(), t.oldState); err != nil {
+		logrus.Errorf("Restore terminal error %v", err)
+	}
+}
+
+// 把当前终端的窗口大小设置到 pty 上
+func resizePty(master *os.File) {
+	ws, err := container.GetWinsize(os.Stdin.Fd())
+	if err != nil {
+		logrus.Errorf("Get terminal size error %v", err)
+		return
+	}
+	if err := container.SetWinsize(master.Fd(), ws); err != nil {
+		logrus.Errorf("Set pty size error %v", err)
+	}
+}